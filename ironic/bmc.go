@@ -0,0 +1,186 @@
+package ironic
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/metal3-io/baremetal-operator/pkg/hardwareutils/bmc"
+)
+
+// Schema for the bmc block of a node, which describes the BMC the same way as metal3's BareMetalHost.
+func bmcSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:         schema.TypeList,
+		Optional:     true,
+		MaxItems:     1,
+		AtLeastOneOf: []string{"driver", "bmc"},
+		Description:  "BMC address and credentials, used to set the driver, interfaces and driver_info of the node",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"address": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "BMC address, e.g. ipmi://192.168.111.1 or redfish-virtualmedia://192.168.111.1/redfish/v1/Systems/1",
+				},
+				"username": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"password": {
+					Type:      schema.TypeString,
+					Optional:  true,
+					Sensitive: true,
+				},
+				"disable_certificate_verification": {
+					Type:     schema.TypeBool,
+					Optional: true,
+				},
+			},
+		},
+	}
+}
+
+// bmcSettings is what is derived from the bmc block of a node
+type bmcSettings struct {
+	driver     string
+	interfaces map[string]string
+	driverInfo map[string]interface{}
+}
+
+// bmcSettingsFromConfig parses a bmc block with metal3's bmc package. It returns nil if there is no bmc block.
+func bmcSettingsFromConfig(raw interface{}) (*bmcSettings, error) {
+	list, _ := raw.([]interface{})
+	if len(list) == 0 || list[0] == nil {
+		return nil, nil
+	}
+	config := list[0].(map[string]interface{})
+
+	accessDetails, err := bmc.NewAccessDetails(config["address"].(string), config["disable_certificate_verification"].(bool))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse bmc address: %s", err)
+	}
+
+	return &bmcSettings{
+		driver: accessDetails.Driver(),
+		interfaces: map[string]string{
+			"bios_interface":       accessDetails.BIOSInterface(),
+			"boot_interface":       accessDetails.BootInterface(),
+			"management_interface": accessDetails.ManagementInterface(),
+			"power_interface":      accessDetails.PowerInterface(),
+			"raid_interface":       accessDetails.RAIDInterface(),
+			"vendor_interface":     accessDetails.VendorInterface(),
+		},
+		driverInfo: accessDetails.DriverInfo(bmc.Credentials{
+			Username: config["username"].(string),
+			Password: config["password"].(string),
+		}),
+	}, nil
+}
+
+// applyBMCToCreateOpts fills in the driver, interfaces and driver_info derived from the bmc block. Anything set
+// explicitly in the resource takes precedence.
+func applyBMCToCreateOpts(d *schema.ResourceData, opts *nodes.CreateOpts) error {
+	settings, err := bmcSettingsFromConfig(d.Get("bmc"))
+	if err != nil || settings == nil {
+		return err
+	}
+
+	if opts.Driver == "" {
+		opts.Driver = settings.driver
+	}
+
+	interfaces := map[string]*string{
+		"bios_interface":       &opts.BIOSInterface,
+		"boot_interface":       &opts.BootInterface,
+		"management_interface": &opts.ManagementInterface,
+		"power_interface":      &opts.PowerInterface,
+		"raid_interface":       &opts.RAIDInterface,
+		"vendor_interface":     &opts.VendorInterface,
+	}
+	for name, value := range interfaces {
+		if *value == "" {
+			*value = settings.interfaces[name]
+		}
+	}
+
+	opts.DriverInfo = driverInfoWithBMC(opts.DriverInfo, settings)
+
+	return nil
+}
+
+// driverInfoWithBMC returns the driver_info derived from the bmc block, overridden by the driver_info of the resource
+func driverInfoWithBMC(driverInfo map[string]interface{}, settings *bmcSettings) map[string]interface{} {
+	result := make(map[string]interface{})
+	if settings != nil {
+		for k, v := range settings.driverInfo {
+			result[k] = v
+		}
+	}
+	for k, v := range driverInfo {
+		result[k] = v
+	}
+	return result
+}
+
+// bmcUpdateOpts builds the patch of the driver and interfaces for a change to the bmc block. They are only changed if
+// they still have the values derived from the previous bmc block.
+func bmcUpdateOpts(d *schema.ResourceData) (nodes.UpdateOpts, error) {
+	oldRaw, newRaw := d.GetChange("bmc")
+	// The previous bmc block was accepted already
+	oldSettings, _ := bmcSettingsFromConfig(oldRaw)
+	newSettings, err := bmcSettingsFromConfig(newRaw)
+	if err != nil {
+		return nil, err
+	}
+	if oldSettings == nil {
+		oldSettings = &bmcSettings{}
+	}
+	if newSettings == nil {
+		newSettings = &bmcSettings{}
+	}
+
+	var opts nodes.UpdateOpts
+
+	if newSettings.driver != "" && newSettings.driver != oldSettings.driver && d.Get("driver").(string) == oldSettings.driver {
+		opts = append(opts, nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver", Value: newSettings.driver})
+	}
+
+	for name, value := range newSettings.interfaces {
+		// bios_interface isn't an attribute of the resource, so it always follows the bmc block
+		current := oldSettings.interfaces[name]
+		if name != "bios_interface" {
+			current = d.Get(name).(string)
+		}
+		if value != "" && value != oldSettings.interfaces[name] && current == oldSettings.interfaces[name] {
+			opts = append(opts, nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/" + name, Value: value})
+		}
+	}
+
+	return opts, nil
+}
+
+// driverInfoWithoutBMC removes the driver_info keys derived from the bmc block from the node's driver_info, unless
+// they are also set explicitly, so they don't show up as changes to driver_info. A derived key that was changed
+// outside of terraform is kept, so the drift shows up as a change to driver_info, which resets it to the value derived
+// from the bmc block. Secrets masked by Ironic can't be compared, so they are always removed.
+func driverInfoWithoutBMC(d *schema.ResourceData, driverInfo map[string]interface{}) map[string]interface{} {
+	settings, err := bmcSettingsFromConfig(d.Get("bmc"))
+	if err != nil || settings == nil {
+		return driverInfo
+	}
+
+	configured := d.Get("driver_info").(map[string]interface{})
+	result := make(map[string]interface{})
+	for k, v := range driverInfo {
+		if derived, ok := settings.driverInfo[k]; ok {
+			_, set := configured[k]
+			if !set && (v == maskedValue || fmt.Sprint(v) == fmt.Sprint(derived)) {
+				continue
+			}
+		}
+		result[k] = v
+	}
+
+	return result
+}
@@ -0,0 +1,153 @@
+package ironic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// Schema for the clean_steps of a node, run during manual cleaning together with the RAID and BIOS steps.
+func cleanStepsSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: "Manual clean steps, run with the steps built from raid_config and bios_settings when the node is cleaned. Steps are refused when the node's interface for them is a no-op one, e.g. 'no-raid'. Firmware steps are only checked when Ironic reports the node's firmware interface, from API version 1.86",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"interface": {
+					Type:     schema.TypeString,
+					Required: true,
+					ValidateFunc: validation.StringInSlice([]string{
+						"bios", "deploy", "firmware", "management", "power", "raid",
+					}, false),
+				},
+				"step": {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				"args": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Description:  "Arguments of the step, in JSON",
+					ValidateFunc: validation.StringIsJSON,
+				},
+				"priority": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Description: "Steps run from the highest priority to the lowest. The RAID and BIOS steps have priority 0",
+				},
+			},
+		},
+	}
+}
+
+// cleanNode runs manual cleaning of the node with the given steps. A node that was available is made available
+// again afterwards, as cleaning leaves it manageable.
+func cleanNode(ctx context.Context, client *gophercloud.ServiceClient, d *schema.ResourceData, cleanSteps []nodes.CleanStep, options ...WorkflowOption) error {
+	result := nodes.Get(client, d.Id())
+	node, err := result.Extract()
+	if err != nil {
+		return err
+	}
+	var status nodeStatus
+	if err := result.ExtractInto(&status); err != nil {
+		return err
+	}
+	if err := validateCleanSteps(node, status, cleanSteps); err != nil {
+		return err
+	}
+
+	if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetClean, nil, nil, cleanSteps, options...); err != nil {
+		return err
+	}
+
+	if node.ProvisionState == string(nodes.Available) {
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetProvide, nil, nil, nil, options...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nodeCleanSteps returns the steps to clean the node with: the ones built from raid_config and bios_settings, and
+// the ones from clean_steps, ordered by priority.
+func nodeCleanSteps(d *schema.ResourceData) ([]nodes.CleanStep, error) {
+	generated, err := buildManualCleaningSteps(d.Get("raid_interface").(string), d.Get("raid_config").(string), d.Get("bios_settings").(string))
+	if err != nil {
+		return nil, fmt.Errorf("fail to build raid clean steps: %s", err)
+	}
+
+	return mergeCleanSteps(generated, d.Get("clean_steps").([]interface{}))
+}
+
+// mergeCleanSteps adds the configured clean steps to the generated ones. The order of steps with the same priority
+// is kept, with the generated steps first.
+func mergeCleanSteps(generated []nodes.CleanStep, configured []interface{}) ([]nodes.CleanStep, error) {
+	type prioritizedStep struct {
+		step     nodes.CleanStep
+		priority int
+	}
+
+	var steps []prioritizedStep
+	for _, step := range generated {
+		steps = append(steps, prioritizedStep{step: step})
+	}
+
+	for _, raw := range configured {
+		config, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		step := nodes.CleanStep{
+			Interface: nodes.StepInterface(config["interface"].(string)),
+			Step:      config["step"].(string),
+		}
+		if args := config["args"].(string); args != "" {
+			if err := json.Unmarshal([]byte(args), &step.Args); err != nil {
+				return nil, fmt.Errorf("could not parse the args of clean step %s.%s: %s", step.Interface, step.Step, err)
+			}
+		}
+		steps = append(steps, prioritizedStep{step: step, priority: config["priority"].(int)})
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].priority > steps[j].priority
+	})
+
+	var cleanSteps []nodes.CleanStep
+	for _, step := range steps {
+		cleanSteps = append(cleanSteps, step.step)
+	}
+	return cleanSteps, nil
+}
+
+// validateCleanSteps checks that the node has an interface able to run each of the clean steps. Interfaces Ironic
+// doesn't report, e.g. the firmware interface before API version 1.86, can't be checked.
+func validateCleanSteps(node *nodes.Node, status nodeStatus, steps []nodes.CleanStep) error {
+	interfaces := map[nodes.StepInterface]string{
+		nodes.InterfaceBIOS:       node.BIOSInterface,
+		nodes.InterfaceDeploy:     node.DeployInterface,
+		nodes.InterfaceManagement: node.ManagementInterface,
+		nodes.InterfacePower:      node.PowerInterface,
+		nodes.InterfaceRAID:       node.RAIDInterface,
+		"firmware":                status.FirmwareInterface,
+	}
+
+	for _, step := range steps {
+		if implementation, ok := interfaces[step.Interface]; ok && strings.HasPrefix(implementation, "no-") {
+			return fmt.Errorf("clean step %s.%s can't run, the node's %s interface is '%s'", step.Interface, step.Step,
+				step.Interface, implementation)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package ironic
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+)
+
+func TestMergeCleanSteps(t *testing.T) {
+	generated := []nodes.CleanStep{
+		{Interface: "raid", Step: "delete_configuration"},
+		{Interface: "raid", Step: "create_configuration"},
+	}
+
+	cases := []struct {
+		Scenario      string
+		Configured    []interface{}
+		Expected      []nodes.CleanStep
+		ExpectedError bool
+	}{
+		{
+			Scenario: "only generated steps",
+			Expected: generated,
+		},
+		{
+			Scenario: "configured steps run after the generated ones by default",
+			Configured: []interface{}{
+				map[string]interface{}{"interface": "management", "step": "update_firmware", "args": `{"firmware_images":[{"url":"http://example.com/bmc.bin"}]}`, "priority": 0},
+			},
+			Expected: []nodes.CleanStep{
+				generated[0],
+				generated[1],
+				{Interface: "management", Step: "update_firmware", Args: map[string]interface{}{
+					"firmware_images": []interface{}{map[string]interface{}{"url": "http://example.com/bmc.bin"}},
+				}},
+			},
+		},
+		{
+			Scenario: "configured steps ordered by priority",
+			Configured: []interface{}{
+				map[string]interface{}{"interface": "bios", "step": "factory_reset", "args": "", "priority": -1},
+				map[string]interface{}{"interface": "deploy", "step": "erase_devices_metadata", "args": "", "priority": 10},
+			},
+			Expected: []nodes.CleanStep{
+				{Interface: "deploy", Step: "erase_devices_metadata"},
+				generated[0],
+				generated[1],
+				{Interface: "bios", Step: "factory_reset"},
+			},
+		},
+		{
+			Scenario: "invalid args",
+			Configured: []interface{}{
+				map[string]interface{}{"interface": "deploy", "step": "erase_devices", "args": "[]", "priority": 0},
+			},
+			ExpectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			steps, err := mergeCleanSteps(generated, c.Configured)
+			if (err != nil) != c.ExpectedError {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if !c.ExpectedError && !reflect.DeepEqual(c.Expected, steps) {
+				t.Errorf("expected: %v, got: %v", c.Expected, steps)
+			}
+		})
+	}
+}
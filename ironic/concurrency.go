@@ -0,0 +1,66 @@
+package ironic
+
+import (
+	"context"
+	"log"
+)
+
+// OperationLimiter limits how many provisioning and power operations run at once, overall and per conductor group,
+// so that applying many resources doesn't overwhelm Ironic's conductors, image server and DHCP. A nil limiter doesn't
+// limit anything.
+type OperationLimiter struct {
+	all    chan struct{}
+	groups map[string]chan struct{}
+}
+
+// NewOperationLimiter returns a limiter allowing max operations at once, and perGroup operations at once on the nodes
+// of each conductor group. Zero means no limit.
+func NewOperationLimiter(max int, perGroup map[string]int) *OperationLimiter {
+	limiter := &OperationLimiter{groups: make(map[string]chan struct{})}
+	if max > 0 {
+		limiter.all = make(chan struct{}, max)
+	}
+	for group, limit := range perGroup {
+		if limit > 0 {
+			limiter.groups[group] = make(chan struct{}, limit)
+		}
+	}
+	return limiter
+}
+
+// Acquire waits until an operation on a node of the conductor group may start, or the context is done. The returned
+// function must be called when the operation is over.
+func (l *OperationLimiter) Acquire(ctx context.Context, group string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	// The group's slot is taken first, so that waiting on a busy group doesn't hold one of the overall slots
+	var slots []chan struct{}
+	for _, slot := range []chan struct{}{l.groups[group], l.all} {
+		if slot == nil {
+			continue
+		}
+		select {
+		case slot <- struct{}{}:
+			slots = append(slots, slot)
+		default:
+			log.Printf("[DEBUG] Too many operations running, waiting for one to finish")
+			select {
+			case slot <- struct{}{}:
+				slots = append(slots, slot)
+			case <-ctx.Done():
+				releaseSlots(slots)
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return func() { releaseSlots(slots) }, nil
+}
+
+func releaseSlots(slots []chan struct{}) {
+	for _, slot := range slots {
+		<-slot
+	}
+}
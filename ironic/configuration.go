@@ -0,0 +1,181 @@
+package ironic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	metal3v1alpha1 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
+	"github.com/metal3-io/baremetal-operator/pkg/provisioner/ironic"
+)
+
+// reconfigureNode applies a changed RAID or BIOS configuration by cleaning the node with only the steps of what
+// changed, then returns the node to the state it was in.
+func reconfigureNode(ctx context.Context, client *gophercloud.ServiceClient, d *schema.ResourceData, options ...WorkflowOption) error {
+	var raidConfig, biosSettings string
+	if d.HasChange("raid_config") {
+		raidConfig = d.Get("raid_config").(string)
+	}
+	if d.HasChange("bios_settings") {
+		biosSettings = d.Get("bios_settings").(string)
+	}
+
+	cleanSteps, err := buildManualCleaningSteps(d.Get("raid_interface").(string), raidConfig, biosSettings)
+	if err != nil {
+		return fmt.Errorf("fail to build raid clean steps: %s", err)
+	}
+	if len(cleanSteps) == 0 {
+		return nil
+	}
+
+	node, err := nodes.Get(client, d.Id()).Extract()
+	if err != nil {
+		return err
+	}
+	switch nodes.ProvisionState(node.ProvisionState) {
+	case nodes.Manageable, nodes.Available:
+	default:
+		return fmt.Errorf("cannot apply the RAID and BIOS configuration to node %s in state '%s', it must be manageable or available",
+			d.Id(), node.ProvisionState)
+	}
+
+	return cleanNode(ctx, client, d, cleanSteps, options...)
+}
+
+// configurationDriftDetectable returns whether the RAID and BIOS configuration of the node is expected to match
+// raid_config and bios_settings: they are applied by cleaning, and can only be applied again to nodes that aren't
+// deployed.
+func configurationDriftDetectable(d *schema.ResourceData, node *nodes.Node) bool {
+	if !d.Get("clean").(bool) {
+		return false
+	}
+
+	switch nodes.ProvisionState(node.ProvisionState) {
+	case nodes.Manageable, nodes.Available:
+		return true
+	default:
+		return false
+	}
+}
+
+// raidConfigFromNode returns the raid_config to record for the node. That's the current value when the RAID
+// configuration in Ironic matches it, otherwise it describes the RAID configuration in Ironic so the difference
+// shows up in the plan.
+func raidConfigFromNode(current string, actual map[string]interface{}) (string, error) {
+	if current == "" {
+		return current, nil
+	}
+
+	var target *metal3v1alpha1.RAIDConfig
+	if err := json.Unmarshal([]byte(current), &target); err != nil {
+		return "", err
+	}
+	logicalDisks, err := ironic.BuildTargetRAIDCfg(target)
+	if err != nil {
+		return "", err
+	}
+
+	actualDisks, _ := actual["logical_disks"].([]interface{})
+	if raidConfigMatches(logicalDisks, actualDisks) {
+		return current, nil
+	}
+
+	log.Printf("[DEBUG] The RAID configuration in Ironic doesn't match raid_config")
+	drifted := metal3v1alpha1.RAIDConfig{}
+	for _, raw := range actualDisks {
+		disk, _ := raw.(map[string]interface{})
+		level := fmt.Sprint(disk["raid_level"])
+		var size *int
+		if sizeGB, ok := disk["size_gb"].(float64); ok {
+			size = new(int)
+			*size = int(sizeGB)
+		}
+
+		if disk["controller"] == "software" {
+			drifted.SoftwareRAIDVolumes = append(drifted.SoftwareRAIDVolumes, metal3v1alpha1.SoftwareRAIDVolume{
+				Level:         level,
+				SizeGibibytes: size,
+			})
+		} else {
+			name, _ := disk["volume_name"].(string)
+			drifted.HardwareRAIDVolumes = append(drifted.HardwareRAIDVolumes, metal3v1alpha1.HardwareRAIDVolume{
+				Level:         level,
+				SizeGibibytes: size,
+				Name:          name,
+			})
+		}
+	}
+
+	result, err := json.Marshal(drifted)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// raidConfigMatches compares the logical disks in Ironic with the target ones, by software or hardware RAID, by RAID
+// level and by size when the target has one.
+func raidConfigMatches(target []nodes.LogicalDisk, actual []interface{}) bool {
+	if len(target) != len(actual) {
+		return false
+	}
+
+	for i, disk := range target {
+		actualDisk, _ := actual[i].(map[string]interface{})
+		if (disk.Controller == "software") != (actualDisk["controller"] == "software") {
+			return false
+		}
+		if fmt.Sprint(actualDisk["raid_level"]) != string(disk.RAIDLevel) {
+			return false
+		}
+		if disk.SizeGB != nil {
+			if size, ok := actualDisk["size_gb"].(float64); !ok || int(size) != *disk.SizeGB {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// biosSettingsFromNode returns the bios_settings to record for the node. Only the settings in bios_settings are
+// compared, the node has many more. When one of them has a different value in Ironic, that value is recorded so
+// the difference shows up in the plan.
+func biosSettingsFromNode(current string, actual []nodes.BIOSSetting) (string, error) {
+	if current == "" {
+		return current, nil
+	}
+
+	var settings []map[string]string
+	if err := json.Unmarshal([]byte(current), &settings); err != nil {
+		return "", err
+	}
+
+	actualValues := make(map[string]string)
+	for _, setting := range actual {
+		actualValues[setting.Name] = setting.Value
+	}
+
+	drifted := false
+	for _, setting := range settings {
+		// Ironic may not know about a setting until the node is cleaned or inspected
+		if value, ok := actualValues[setting["name"]]; ok && value != setting["value"] {
+			log.Printf("[DEBUG] BIOS setting %s is '%s' in Ironic, but '%s' in bios_settings", setting["name"], value, setting["value"])
+			setting["value"] = value
+			drifted = true
+		}
+	}
+	if !drifted {
+		return current, nil
+	}
+
+	result, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
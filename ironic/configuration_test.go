@@ -0,0 +1,96 @@
+package ironic
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+)
+
+func TestRAIDConfigFromNode(t *testing.T) {
+	current := `{"hardwareRAIDVolumes":[{"level":"1","sizeGibibytes":100}],"softwareRAIDVolumes":null}`
+
+	cases := []struct {
+		Scenario string
+		Actual   map[string]interface{}
+		Expected string
+	}{
+		{
+			Scenario: "matching",
+			Actual: map[string]interface{}{"logical_disks": []interface{}{
+				map[string]interface{}{"raid_level": "1", "size_gb": float64(100), "is_root_volume": true},
+			}},
+			Expected: current,
+		},
+		{
+			Scenario: "different size",
+			Actual: map[string]interface{}{"logical_disks": []interface{}{
+				map[string]interface{}{"raid_level": "1", "size_gb": float64(200)},
+			}},
+			Expected: `{"hardwareRAIDVolumes":[{"sizeGibibytes":200,"level":"1"}],"softwareRAIDVolumes":null}`,
+		},
+		{
+			Scenario: "software RAID",
+			Actual: map[string]interface{}{"logical_disks": []interface{}{
+				map[string]interface{}{"raid_level": "1", "size_gb": float64(100), "controller": "software"},
+			}},
+			Expected: `{"hardwareRAIDVolumes":null,"softwareRAIDVolumes":[{"sizeGibibytes":100,"level":"1"}]}`,
+		},
+		{
+			Scenario: "no RAID configuration",
+			Actual:   map[string]interface{}{},
+			Expected: `{"hardwareRAIDVolumes":null,"softwareRAIDVolumes":null}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			raidConfig, err := raidConfigFromNode(current, c.Actual)
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if raidConfig != c.Expected {
+				t.Errorf("expected: %s, got: %s", c.Expected, raidConfig)
+			}
+		})
+	}
+}
+
+func TestBIOSSettingsFromNode(t *testing.T) {
+	current := `[{"name":"ProcTurboMode","value":"Enabled"},{"name":"BootMode","value":"Uefi"}]`
+
+	cases := []struct {
+		Scenario string
+		Actual   []nodes.BIOSSetting
+		Expected string
+	}{
+		{
+			Scenario: "matching, ignoring other settings",
+			Actual: []nodes.BIOSSetting{
+				{Name: "BootMode", Value: "Uefi"}, {Name: "ProcTurboMode", Value: "Enabled"}, {Name: "LogicalProc", Value: "Enabled"},
+			},
+			Expected: current,
+		},
+		{
+			Scenario: "unknown to Ironic",
+			Actual:   []nodes.BIOSSetting{{Name: "BootMode", Value: "Uefi"}},
+			Expected: current,
+		},
+		{
+			Scenario: "different value",
+			Actual:   []nodes.BIOSSetting{{Name: "BootMode", Value: "Bios"}, {Name: "ProcTurboMode", Value: "Enabled"}},
+			Expected: `[{"name":"ProcTurboMode","value":"Enabled"},{"name":"BootMode","value":"Bios"}]`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			settings, err := biosSettingsFromNode(current, c.Actual)
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if settings != c.Expected {
+				t.Errorf("expected: %s, got: %s", c.Expected, settings)
+			}
+		})
+	}
+}
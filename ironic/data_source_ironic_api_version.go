@@ -0,0 +1,38 @@
+package ironic
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Schema resource for a data source with the Ironic API version the provider uses, after negotiating it when the
+// provider's microversion is `auto`.
+func dataSourceIronicAPIVersion() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceIronicAPIVersionRead,
+		Schema: map[string]*schema.Schema{
+			"microversion": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The microversion of the requests to Ironic, either the configured or the negotiated one",
+			},
+		},
+	}
+}
+
+func dataSourceIronicAPIVersionRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("microversion", client.Microversion)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(client.Endpoint)
+	return nil
+}
@@ -0,0 +1,141 @@
+package ironic
+
+import (
+	"context"
+	"log"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Schema resource for a data source with the BIOS settings of a node, as cached by Ironic.
+func dataSourceIronicNodeBIOSSettings() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceIronicNodeBIOSSettingsRead,
+		Schema: map[string]*schema.Schema{
+			"node_uuid": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"settings": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The BIOS settings of the node. All but name and value are only known with Ironic API version 1.74 or newer",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"value": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"attribute_type": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Enumeration, String, Integer or Boolean",
+						},
+						"allowable_values": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "The values allowed for an Enumeration setting",
+						},
+						"lower_bound": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"upper_bound": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"min_length": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"max_length": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"read_only": {
+							Type:     schema.TypeBool,
+							Computed: true,
+						},
+						"reset_required": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Whether the node must be rebooted for a change of the setting to be applied",
+						},
+						"unique": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Whether the value is unique to the node, e.g. a serial number",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceIronicNodeBIOSSettingsRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	uuid := d.Get("node_uuid").(string)
+
+	// Older versions of Ironic only return the name and value of the settings
+	var opts nodes.ListBIOSSettingsOptsBuilder
+	if requireMicroversion(client, microversionBIOSDetail, "BIOS setting details") == nil {
+		opts = nodes.ListBIOSSettingsOpts{Detail: true}
+	} else {
+		log.Printf("[DEBUG] Listing the BIOS settings of node %s without details", uuid)
+	}
+
+	settings, err := nodes.ListBIOSSettings(client, uuid, opts).Extract()
+	if err != nil {
+		return diag.Errorf("could not get BIOS settings: %s", err)
+	}
+
+	var result []map[string]interface{}
+	for _, setting := range settings {
+		result = append(result, map[string]interface{}{
+			"name":             setting.Name,
+			"value":            setting.Value,
+			"attribute_type":   setting.AttributeType,
+			"allowable_values": setting.AllowableValues,
+			"lower_bound":      intValue(setting.LowerBound),
+			"upper_bound":      intValue(setting.UpperBound),
+			"min_length":       intValue(setting.MinLength),
+			"max_length":       intValue(setting.MaxLength),
+			"read_only":        boolValue(setting.ReadOnly),
+			"reset_required":   boolValue(setting.ResetRequired),
+			"unique":           boolValue(setting.Unique),
+		})
+	}
+	err = d.Set("settings", result)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(uuid)
+	return nil
+}
+
+func intValue(value *int) int {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+func boolValue(value *bool) bool {
+	if value == nil {
+		return false
+	}
+	return *value
+}
@@ -0,0 +1,111 @@
+package ironic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+var (
+	// ErrProvisionFailed is the cause of a ProvisionError when Ironic left the node in a failure state, and the retry
+	// policy gave up on it.
+	ErrProvisionFailed = errors.New("provisioning failed")
+
+	// ErrNodeInMaintenance is the cause of a ProvisionError when the node is in maintenance, and the workflow isn't
+	// allowed to change it.
+	ErrNodeInMaintenance = errors.New("in maintenance")
+)
+
+// ProvisionError is returned by ChangeProvisionStateToTarget when the node can't be moved to the target. Err is the
+// cause: ErrProvisionFailed, ErrNodeInMaintenance, a StallError, a statemachine.NoPathError, the context's error or
+// the error of a request to Ironic.
+type ProvisionError struct {
+	NodeUUID string
+
+	// The state the node is in, and the target it couldn't be moved to
+	State  nodes.ProvisionState
+	Target nodes.TargetProvisionState
+
+	// The node's last_error, from before aborting when the workflow aborted Ironic's operation
+	LastError string
+
+	// How many times the workflow retried before giving up
+	Retries int
+
+	// The HTTP status code when a request to Ironic failed, zero otherwise
+	StatusCode int
+
+	Err error
+}
+
+func (e *ProvisionError) Error() string {
+	message := fmt.Sprintf("node %s: %s", e.NodeUUID, e.Err)
+	if e.LastError != "" {
+		message += fmt.Sprintf(", last error was '%s'", e.LastError)
+	}
+	return message
+}
+
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// StallError is the cause of a ProvisionError when the node stayed in a provision state for longer than its stall
+// timeout.
+type StallError struct {
+	State nodes.ProvisionState
+
+	// Ironic's provision_updated_at of the node
+	Since string
+
+	// Whether the workflow aborted Ironic's operation, or undeployed the node when it stalled deploying
+	Aborted bool
+
+	lastError string
+}
+
+func (e *StallError) Error() string {
+	message := fmt.Sprintf("stalled in state '%s' since %s", e.State, e.Since)
+	if e.Aborted {
+		message += ", aborted it"
+	}
+	return message
+}
+
+// provisionDiagnostics renders an error as diagnostics summarized as "summary: error", attached to the attribute when
+// it's set. When the error comes from changing the provision state of a node, only its cause is in the summary, and
+// the node and its state are in the detail.
+func provisionDiagnostics(summary string, err error, attribute string) diag.Diagnostics {
+	diagnostic := diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  fmt.Sprintf("%s: %s", summary, err),
+	}
+	if attribute != "" {
+		diagnostic.AttributePath = cty.GetAttrPath(attribute)
+	}
+
+	var provisionErr *ProvisionError
+	if errors.As(err, &provisionErr) {
+		diagnostic.Summary = fmt.Sprintf("%s: %s", summary, provisionErr.Err)
+
+		detail := []string{
+			fmt.Sprintf("Node: %s", provisionErr.NodeUUID),
+			fmt.Sprintf("Provision state: %s", provisionErr.State),
+			fmt.Sprintf("Target: %s", provisionErr.Target),
+			fmt.Sprintf("Retries: %d", provisionErr.Retries),
+		}
+		if provisionErr.StatusCode != 0 {
+			detail = append(detail, fmt.Sprintf("Ironic API status: %d", provisionErr.StatusCode))
+		}
+		if provisionErr.LastError != "" {
+			detail = append(detail, fmt.Sprintf("Last error: %s", provisionErr.LastError))
+		}
+		diagnostic.Detail = strings.Join(detail, "\n")
+	}
+
+	return diag.Diagnostics{diagnostic}
+}
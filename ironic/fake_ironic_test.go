@@ -0,0 +1,959 @@
+package ironic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/ports"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	th "github.com/openshift-metal3/terraform-provider-ironic/testhelper"
+)
+
+// These tests run the resources against the fake Ironic from the testhelper package, so they don't need a real
+// Ironic and aren't gated by the acceptance build tag.
+
+func TestFakeIronic_node(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"name":               "node-0",
+		"driver":             "fake-hardware",
+		"available":          true,
+		"target_power_state": "power on",
+		"ports": []interface{}{
+			map[string]interface{}{"address": "52:54:00:cf:2d:31", "pxe_enabled": "true"},
+		},
+	})
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+
+	node := fake.Node(d.Id())
+	if node["provision_state"] != "available" || node["power_state"] != "power on" {
+		t.Errorf("expected an available node that is powered on, got '%s' and '%s'", node["provision_state"], node["power_state"])
+	}
+	if d.Get("provision_state") != "available" {
+		t.Errorf("expected provision_state to be read back, got '%s'", d.Get("provision_state"))
+	}
+
+	d = fakeUpdateData(t, r, d, clients, map[string]interface{}{
+		"name":               "node-1",
+		"driver":             "fake-hardware",
+		"available":          true,
+		"target_power_state": "power off",
+		"ports": []interface{}{
+			map[string]interface{}{"address": "52:54:00:cf:2d:31", "pxe_enabled": "true"},
+		},
+	})
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+
+	node = fake.Node(d.Id())
+	if node["name"] != "node-1" || node["power_state"] != "power off" {
+		t.Errorf("expected the node to be renamed and powered off, got '%s' and '%s'", node["name"], node["power_state"])
+	}
+
+	assertNoDiags(t, resourceNodeV1Delete(ctx, d, clients))
+	if fake.Node(d.Id()) != nil {
+		t.Errorf("expected the node to be deleted")
+	}
+}
+
+func TestFakeIronic_nodeUpdateFields(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"driver": "fake-hardware",
+		"driver_info": map[string]interface{}{
+			"ipmi_address":  "192.168.111.1",
+			"ipmi_username": "admin",
+			"ipmi_password": "secret",
+		},
+		"extra":       map[string]interface{}{"rack": "r1", "row": "a"},
+		"properties":  map[string]interface{}{"cpu_arch": "x86_64"},
+		"root_device": map[string]interface{}{"name": "/dev/sda"},
+		"ports": []interface{}{
+			map[string]interface{}{"address": "52:54:00:cf:2d:31"},
+		},
+	})
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+
+	d = fakeUpdateData(t, r, d, clients, map[string]interface{}{
+		"driver": "fake-hardware",
+		"driver_info": map[string]interface{}{
+			"ipmi_address":  "192.168.111.2",
+			"ipmi_username": "admin",
+			"ipmi_password": "secret",
+		},
+		"extra":         map[string]interface{}{"rack": "r2"},
+		"properties":    map[string]interface{}{"cpu_arch": "x86_64"},
+		"instance_info": map[string]interface{}{"capabilities": "boot_mode:uefi"},
+		"ports": []interface{}{
+			map[string]interface{}{"address": "52:54:00:cf:2d:32"},
+		},
+	})
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+
+	node := fake.Node(d.Id())
+	driverInfo := node["driver_info"].(map[string]interface{})
+	if driverInfo["ipmi_address"] != "192.168.111.2" || driverInfo["ipmi_password"] != "secret" {
+		t.Errorf("expected only the BMC address to change, got %v", driverInfo)
+	}
+	if extra := node["extra"].(map[string]interface{}); len(extra) != 1 || extra["rack"] != "r2" {
+		t.Errorf("expected extra to be updated, got %v", extra)
+	}
+	if properties := node["properties"].(map[string]interface{}); properties["root_device"] != nil || properties["cpu_arch"] != "x86_64" {
+		t.Errorf("expected the root device to be removed from properties, got %v", properties)
+	}
+	capabilities := node["instance_info"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if capabilities["boot_mode"] != "uefi" {
+		t.Errorf("expected capabilities to be sent as a map, got %v", capabilities)
+	}
+	if d.Get("instance_info.capabilities") != "boot_mode:uefi" {
+		t.Errorf("expected capabilities to be read back, got %v", d.Get("instance_info"))
+	}
+
+	client, err := clients.GetIronicClient()
+	th.AssertNoError(t, err)
+	page, err := ports.List(client, ports.ListOpts{Node: d.Id()}).AllPages()
+	th.AssertNoError(t, err)
+	nodePorts, err := ports.ExtractPorts(page)
+	th.AssertNoError(t, err)
+	if len(nodePorts) != 1 || nodePorts[0].Address != "52:54:00:cf:2d:32" {
+		t.Errorf("expected the inline port to be replaced, got %v", nodePorts)
+	}
+}
+
+func TestFakeIronic_nodeCredentialRotation(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	config := func(password string) map[string]interface{} {
+		return map[string]interface{}{
+			"driver": "redfish",
+			"driver_info": map[string]interface{}{
+				"redfish_address":  "https://192.168.111.1",
+				"redfish_username": "admin",
+				"redfish_password": password,
+			},
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config("secret"))
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+	digest := d.Get("driver_info.redfish_password").(string)
+	if !strings.HasPrefix(digest, secretDigestPrefix) || !verifySecretDigest(d.Id(), digest, "secret") {
+		t.Fatalf("expected the digest of the password in the state, got %v", d.State().Attributes)
+	}
+	if verifySecretDigest("0b7f3d2e-9a41-4c5e-8f6a-1d2c3b4a5e69", digest, "secret") {
+		t.Errorf("expected the digest to be keyed by the node")
+	}
+
+	// A refresh keeps the digest, even though Ironic only returns the masked value
+	assertNoDiags(t, resourceNodeV1Read(ctx, d, clients))
+	if d.Get("driver_info.redfish_password") != digest {
+		t.Fatalf("expected the digest to be kept on refresh, got %v", d.State().Attributes)
+	}
+
+	diff, err := r.Diff(ctx, d.State(), terraform.NewResourceConfigRaw(config("secret")), clients)
+	th.AssertNoError(t, err)
+	if diff != nil && len(diff.Attributes) != 0 {
+		t.Errorf("expected no changes for an unchanged password, got %v", diff.Attributes)
+	}
+
+	d = fakeUpdateData(t, r, d, clients, config("rotated"))
+	if !d.HasChange("driver_info") {
+		t.Fatalf("expected the password rotation to be detected")
+	}
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+
+	if password := fake.Node(d.Id())["driver_info"].(map[string]interface{})["redfish_password"]; password != "rotated" {
+		t.Errorf("expected the rotated password to be sent to Ironic, got '%s'", password)
+	}
+	if !verifySecretDigest(d.Id(), d.Get("driver_info.redfish_password").(string), "rotated") {
+		t.Errorf("expected the digest to be updated, got %v", d.State().Attributes)
+	}
+}
+
+func TestFakeIronic_nodeBMC(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	config := func(address string) map[string]interface{} {
+		return map[string]interface{}{
+			"bmc": []interface{}{
+				map[string]interface{}{
+					"address":  address,
+					"username": "admin",
+					"password": "secret",
+				},
+			},
+			"driver_info": map[string]interface{}{
+				"deploy_kernel": "http://example.com/ipa.kernel",
+			},
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config("redfish-virtualmedia://192.168.111.1/redfish/v1/Systems/1"))
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+
+	node := fake.Node(d.Id())
+	driverInfo := node["driver_info"].(map[string]interface{})
+	if node["driver"] != "redfish" || node["boot_interface"] != "redfish-virtual-media" {
+		t.Errorf("expected the driver and interfaces to be derived from the bmc address, got %v", node)
+	}
+	if driverInfo["redfish_address"] != "https://192.168.111.1" || driverInfo["redfish_system_id"] != "/redfish/v1/Systems/1" ||
+		driverInfo["redfish_password"] != "secret" || driverInfo["deploy_kernel"] != "http://example.com/ipa.kernel" {
+		t.Errorf("expected driver_info to be derived from the bmc block, got %v", driverInfo)
+	}
+
+	// The derived driver_info doesn't show up as a change to driver_info
+	diff, err := r.Diff(ctx, d.State(), terraform.NewResourceConfigRaw(config("redfish-virtualmedia://192.168.111.1/redfish/v1/Systems/1")), clients)
+	th.AssertNoError(t, err)
+	if diff != nil && len(diff.Attributes) != 0 {
+		t.Errorf("expected no changes for an unchanged bmc block, got %v", diff.Attributes)
+	}
+
+	d = fakeUpdateData(t, r, d, clients, config("ipmi://192.168.111.2:6230"))
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+
+	node = fake.Node(d.Id())
+	driverInfo = node["driver_info"].(map[string]interface{})
+	if node["driver"] != "ipmi" || node["boot_interface"] != "ipxe" {
+		t.Errorf("expected the driver and interfaces to follow the bmc address, got %v", node)
+	}
+	if driverInfo["ipmi_address"] != "192.168.111.2" || driverInfo["ipmi_port"] != "6230" || driverInfo["ipmi_password"] != "secret" ||
+		driverInfo["redfish_address"] != nil || driverInfo["deploy_kernel"] != "http://example.com/ipa.kernel" {
+		t.Errorf("expected driver_info to follow the bmc address, got %v", driverInfo)
+	}
+
+	// A BMC address changed outside of terraform is reported as drift, and reset to the one of the bmc block
+	client, err := clients.GetIronicClient()
+	th.AssertNoError(t, err)
+	_, err = nodes.Update(client, d.Id(), nodes.UpdateOpts{
+		nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver_info/ipmi_address", Value: "192.168.111.9"},
+	}).Extract()
+	th.AssertNoError(t, err)
+	assertNoDiags(t, resourceNodeV1Read(ctx, d, clients))
+
+	d = fakeUpdateData(t, r, d, clients, config("ipmi://192.168.111.2:6230"))
+	if !d.HasChange("driver_info") {
+		t.Fatalf("expected the changed BMC address to be detected, got %v", d.State().Attributes)
+	}
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+	if address := fake.Node(d.Id())["driver_info"].(map[string]interface{})["ipmi_address"]; address != "192.168.111.2" {
+		t.Errorf("expected the BMC address to be reset, got %v", address)
+	}
+
+	diff, err = r.Diff(ctx, d.State(), terraform.NewResourceConfigRaw(config("ipmi://192.168.111.2:6230")), clients)
+	th.AssertNoError(t, err)
+	if diff != nil && len(diff.Attributes) != 0 {
+		t.Errorf("expected no changes once the BMC address was reset, got %v", diff.Attributes)
+	}
+}
+
+func TestFakeIronic_nodeTraits(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	config := func(traits ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"driver":         "fake-hardware",
+			"resource_class": "baremetal",
+			"traits":         traits,
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config("CUSTOM_GPU", "CUSTOM_RAID"))
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+	if traits := fake.Node(d.Id())["traits"]; fmt.Sprint(traits) != "[CUSTOM_GPU CUSTOM_RAID]" {
+		t.Errorf("expected the traits to be set on the node, got %v", traits)
+	}
+
+	d = fakeUpdateData(t, r, d, clients, config("CUSTOM_RAID", "CUSTOM_STORAGE"))
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+	if traits := fake.Node(d.Id())["traits"]; fmt.Sprint(traits) != "[CUSTOM_RAID CUSTOM_STORAGE]" {
+		t.Errorf("expected the traits to be added and removed individually, got %v", traits)
+	}
+
+	// Traits set outside of terraform are left alone when the config doesn't set them
+	unset := map[string]interface{}{"driver": "fake-hardware"}
+	other := schema.TestResourceDataRaw(t, r.Schema, unset)
+	assertNoDiags(t, resourceNodeV1Create(ctx, other, clients))
+	client, err := clients.GetIronicClient()
+	th.AssertNoError(t, err)
+	th.AssertNoError(t, updateNodeTraits(client, other.Id(), schema.NewSet(schema.HashString, nil),
+		schema.NewSet(schema.HashString, []interface{}{"CUSTOM_CLI"})))
+	assertNoDiags(t, resourceNodeV1Read(ctx, other, clients))
+	other = fakeUpdateData(t, r, other, clients, unset)
+	if other.HasChange("traits") {
+		t.Errorf("expected no change to the traits set outside of terraform")
+	}
+	assertNoDiags(t, resourceNodeV1Update(ctx, other, clients))
+	if traits := fake.Node(other.Id())["traits"]; fmt.Sprint(traits) != "[CUSTOM_CLI]" {
+		t.Errorf("expected the traits to be kept, got %v", traits)
+	}
+
+	// Allocations can now find the node by its traits
+	allocation := schema.TestResourceDataRaw(t, resourceAllocationV1().Schema, map[string]interface{}{
+		"name":           "traits",
+		"resource_class": "baremetal",
+		"traits":         []interface{}{"CUSTOM_STORAGE"},
+	})
+	fake.SetProvisionState(d.Id(), "available")
+	assertNoDiags(t, resourceAllocationV1Create(ctx, allocation, clients))
+	if nodeUUID := allocation.Get("node_uuid"); nodeUUID != d.Id() {
+		t.Errorf("expected the allocation to find the node by its traits, got '%s'", nodeUUID)
+	}
+}
+
+func TestFakeIronic_nodeMaintenance(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	config := func(maintenance bool, clean bool) map[string]interface{} {
+		return map[string]interface{}{
+			"driver":             "fake-hardware",
+			"manage":             true,
+			"clean":              clean,
+			"maintenance":        maintenance,
+			"maintenance_reason": "broken fan",
+		}
+	}
+
+	// Maintenance is entered after the node is provisioned
+	d := schema.TestResourceDataRaw(t, r.Schema, config(true, false))
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+	if node := fake.Node(d.Id()); node["provision_state"] != "manageable" || node["maintenance"] != true || node["maintenance_reason"] != "broken fan" {
+		t.Fatalf("expected a manageable node in maintenance, got %v", node)
+	}
+
+	d = fakeUpdateData(t, r, d, clients, config(true, true))
+	diags := resourceNodeV1Update(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "in maintenance") {
+		t.Fatalf("expected cleaning a node in maintenance to be refused, got %v", diags)
+	}
+
+	d = fakeUpdateData(t, r, d, clients, config(false, false))
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+	if node := fake.Node(d.Id()); node["maintenance"] != false || node["maintenance_reason"] != nil {
+		t.Errorf("expected the node to leave maintenance, got %v", node)
+	}
+
+	// Maintenance set outside of terraform is left alone when the config doesn't set it
+	unset := map[string]interface{}{"driver": "fake-hardware", "manage": true}
+	d = schema.TestResourceDataRaw(t, r.Schema, unset)
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+	client, err := clients.GetIronicClient()
+	th.AssertNoError(t, err)
+	th.AssertNoError(t, setNodeMaintenance(client, d.Id(), "replacing a disk"))
+	assertNoDiags(t, resourceNodeV1Read(ctx, d, clients))
+	d = fakeUpdateData(t, r, d, clients, unset)
+	if d.HasChanges("maintenance", "maintenance_reason") {
+		t.Errorf("expected no change to the maintenance set outside of terraform")
+	}
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+	if node := fake.Node(d.Id()); node["maintenance"] != true || node["maintenance_reason"] != "replacing a disk" {
+		t.Errorf("expected the node to stay in maintenance, got %v", node)
+	}
+
+	// A node in maintenance can be destroyed, whether or not it needs undeploying
+	for _, state := range []string{"available", "active"} {
+		d = schema.TestResourceDataRaw(t, r.Schema, config(true, false))
+		assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+		fake.SetProvisionState(d.Id(), state)
+		assertNoDiags(t, resourceNodeV1Delete(ctx, d, clients))
+		if node := fake.Node(d.Id()); node != nil {
+			t.Errorf("expected the %s node in maintenance to be deleted, got %v", state, node)
+		}
+	}
+
+	// The fault that put a node in maintenance is read back
+	uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "maintenance": true, "fault": "power failure"})
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{})
+	d.SetId(uuid)
+	assertNoDiags(t, resourceNodeV1Read(ctx, d, clients))
+	if d.Get("maintenance") != true || d.Get("fault") != "power failure" {
+		t.Errorf("expected the maintenance and fault to be read back, got %v", d.State().Attributes)
+	}
+}
+
+func TestFakeIronic_nodeStatus(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+
+	uuid := fake.CreateNode(map[string]interface{}{
+		"driver":             "fake-hardware",
+		"provision_state":    "clean failed",
+		"last_error":         "cleaning timed out",
+		"properties":         map[string]interface{}{"capabilities": "boot_mode:uefi,cpu_vt:true"},
+		"raid_config":        map[string]interface{}{"logical_disks": []interface{}{map[string]interface{}{"size_gb": 100, "raid_level": "1"}}},
+		"target_raid_config": map[string]interface{}{"logical_disks": []interface{}{map[string]interface{}{"size_gb": "MAX", "raid_level": "0"}}},
+	})
+	d := schema.TestResourceDataRaw(t, resourceNodeV1().Schema, map[string]interface{}{})
+	d.SetId(uuid)
+	assertNoDiags(t, resourceNodeV1Read(ctx, d, clients))
+
+	expected := map[string]interface{}{
+		"last_error":             "cleaning timed out",
+		"conductor":              "fake-conductor",
+		"capabilities.boot_mode": "uefi",
+		"capabilities.cpu_vt":    "true",
+		"current_raid_config":    `{"logical_disks":[{"raid_level":"1","size_gb":100}]}`,
+		"target_raid_config":     `{"logical_disks":[{"raid_level":"0","size_gb":"MAX"}]}`,
+		"inspection_started_at":  "",
+	}
+	for key, value := range expected {
+		if actual := d.Get(key); actual != value {
+			t.Errorf("expected %s to be '%v', got '%v'", key, value, actual)
+		}
+	}
+	if d.Get("provision_updated_at") == "" {
+		t.Errorf("expected provision_updated_at to be set")
+	}
+}
+
+func TestFakeIronic_nodeAdopt(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"driver": "fake-hardware",
+		"adopt":  true,
+	})
+	diags := resourceNodeV1Create(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "image_source") {
+		t.Fatalf("expected adoption without image_source to be refused, got %v", diags)
+	}
+
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"driver": "fake-hardware",
+		"adopt":  true,
+		"instance_info": map[string]interface{}{
+			"image_source": "http://example.com/image.qcow2",
+		},
+	})
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+
+	node := fake.Node(d.Id())
+	if node["provision_state"] != "active" || fmt.Sprint(fake.ProvisionTargets(d.Id())) != "[manage adopt]" {
+		t.Errorf("expected the node to be adopted, got %v", node)
+	}
+	if imageSource := node["instance_info"].(map[string]interface{})["image_source"]; imageSource != "http://example.com/image.qcow2" {
+		t.Errorf("expected image_source to be set before adopting the node, got '%v'", imageSource)
+	}
+
+	// Before API version 1.17 Ironic can't adopt nodes
+	clients.ironic.Microversion = "1.16"
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"driver": "fake-hardware",
+		"adopt":  true,
+		"instance_info": map[string]interface{}{
+			"image_source": "http://example.com/image.qcow2",
+		},
+	})
+	diags = resourceNodeV1Create(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "adopt requires Ironic API version 1.17") {
+		t.Errorf("expected adoption to require API version 1.17, got %v", diags)
+	}
+}
+
+func TestFakeIronic_nodeCleanSteps(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	config := func(steps ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"driver":         "fake-hardware",
+			"raid_interface": "no-raid",
+			"clean":          true,
+			"bios_settings":  `[{"name":"hyper_threading_enabled","value":"True"}]`,
+			"clean_steps":    steps,
+		}
+	}
+	eraseMetadata := map[string]interface{}{"interface": "deploy", "step": "erase_devices_metadata", "priority": 10}
+	eraseDevices := map[string]interface{}{"interface": "deploy", "step": "erase_devices", "args": `{"force": true}`}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config(eraseMetadata))
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+	if steps := fake.CleanSteps(d.Id()); len(steps) != 1 || fmt.Sprint(steps[0]) !=
+		"[map[interface:deploy step:erase_devices_metadata] map[args:map[settings:[map[name:hyper_threading_enabled value:True]]] interface:bios step:apply_configuration]]" {
+		t.Errorf("expected the configured and BIOS steps to be merged, got %v", steps)
+	}
+
+	// Changing the steps cleans the node again
+	d = fakeUpdateData(t, r, d, clients, config(eraseMetadata, eraseDevices))
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+	if steps := fake.CleanSteps(d.Id()); len(steps) != 2 || len(steps[1]) != 3 {
+		t.Errorf("expected the node to be cleaned with the new steps, got %v", steps)
+	}
+
+	// The node's interfaces must support the steps
+	d = fakeUpdateData(t, r, d, clients, config(map[string]interface{}{"interface": "raid", "step": "delete_configuration"}))
+	diags := resourceNodeV1Update(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "raid interface is 'no-raid'") {
+		t.Errorf("expected the raid step to be refused, got %v", diags)
+	}
+
+	// Firmware steps are checked when Ironic reports the firmware interface
+	client, err := clients.GetIronicClient()
+	th.AssertNoError(t, err)
+	uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "manageable", "firmware_interface": "no-firmware"})
+	d.SetId(uuid)
+	err = cleanNode(ctx, client, d, []nodes.CleanStep{{Interface: "firmware", Step: "update"}})
+	th.AssertError(t, err, "firmware interface is 'no-firmware'")
+}
+
+func TestFakeIronic_nodeConfigurationDrift(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	config := map[string]interface{}{
+		"bmc": []interface{}{
+			map[string]interface{}{"address": "idrac-redfish://192.168.111.1/redfish/v1/Systems/System.Embedded.1", "username": "admin", "password": "secret"},
+		},
+		"raid_interface": "idrac-redfish",
+		"clean":          true,
+		"available":      true,
+		"raid_config":    `{"hardwareRAIDVolumes":[{"level":"1","sizeGibibytes":100}],"softwareRAIDVolumes":null}`,
+		"bios_settings":  `[{"name":"ProcTurboMode","value":"Enabled"}]`,
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config)
+	assertNoDiags(t, resourceNodeV1Create(ctx, d, clients))
+	if raidConfig := d.Get("current_raid_config"); raidConfig != `{"logical_disks":[{"is_root_volume":true,"raid_level":"1","size_gb":100}]}` {
+		t.Errorf("expected the RAID configuration to be applied, got %v", raidConfig)
+	}
+
+	diff, err := r.Diff(ctx, d.State(), terraform.NewResourceConfigRaw(config), clients)
+	th.AssertNoError(t, err)
+	if diff != nil && len(diff.Attributes) != 0 {
+		t.Errorf("expected no changes after applying the configuration, got %v", diff.Attributes)
+	}
+
+	// A BIOS setting changed outside of terraform shows up as a change
+	fake.SetBIOSSettings(d.Id(), map[string]string{"ProcTurboMode": "Disabled", "BootMode": "Uefi"})
+	assertNoDiags(t, resourceNodeV1Read(ctx, d, clients))
+	if settings := d.Get("bios_settings"); settings != `[{"name":"ProcTurboMode","value":"Disabled"}]` {
+		t.Errorf("expected the changed BIOS setting to be read back, got %v", settings)
+	}
+
+	// Applying the configuration again cleans the node, and makes it available again
+	d = fakeUpdateData(t, r, d, clients, config)
+	assertNoDiags(t, resourceNodeV1Update(ctx, d, clients))
+	if targets := fake.ProvisionTargets(d.Id()); fmt.Sprint(targets) != "[manage clean provide manage clean provide]" {
+		t.Errorf("expected the node to be cleaned and made available again, but the requests were %v", targets)
+	}
+	if steps := fake.CleanSteps(d.Id()); fmt.Sprint(steps[1]) != "[map[args:map[settings:[map[name:ProcTurboMode value:Enabled]]] interface:bios step:apply_configuration]]" {
+		t.Errorf("expected only the BIOS step to be applied, got %v", steps[1])
+	}
+	if settings := d.Get("bios_settings"); settings != config["bios_settings"] {
+		t.Errorf("expected the BIOS setting to be applied, got %v", settings)
+	}
+}
+
+func TestFakeIronic_nodeImport(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceNodeV1()
+
+	for state, expected := range map[string]map[string]bool{
+		"manageable": {"manage": true, "available": false},
+		"available":  {"manage": false, "available": true},
+		"active":     {"manage": false, "available": false},
+	} {
+		nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": state})
+
+		imported := r.Data(nil)
+		imported.SetId(nodeUUID)
+		result, err := resourceNodeV1Import(ctx, imported, clients)
+		th.AssertNoError(t, err)
+		for attribute, value := range expected {
+			if result[0].Get(attribute) != value {
+				t.Errorf("expected %s to be %v for a node in %s, got %v", attribute, value, state, result[0].Get(attribute))
+			}
+		}
+	}
+}
+
+func TestFakeIronic_port(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourcePortV1()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware"})
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"node_uuid":   nodeUUID,
+		"address":     "52:54:00:cf:2d:31",
+		"pxe_enabled": true,
+	})
+	assertNoDiags(t, resourcePortV1Create(ctx, d, clients))
+
+	d = fakeUpdateData(t, r, d, clients, map[string]interface{}{
+		"node_uuid":             nodeUUID,
+		"address":               "52:54:00:cf:2d:32",
+		"port_group_uuid":       "2b1e5a4e-1d33-4b1e-9d0a-7c8f9e5b6a21",
+		"physical_network":      "provisioning",
+		"pxe_enabled":           false,
+		"is_smart_nic":          true,
+		"local_link_connection": map[string]interface{}{"switch_id": "0a:1b:2c:3d:4e:5f"},
+		"extra":                 map[string]interface{}{"rack": "r1"},
+	})
+	assertNoDiags(t, resourcePortV1Update(ctx, d, clients))
+
+	port := fake.Port(d.Id())
+	if port["address"] != "52:54:00:cf:2d:32" || port["pxe_enabled"] != false || port["extra"].(map[string]interface{})["rack"] != "r1" {
+		t.Errorf("port was not updated: %v", port)
+	}
+	if port["portgroup_uuid"] != "2b1e5a4e-1d33-4b1e-9d0a-7c8f9e5b6a21" || port["physical_network"] != "provisioning" || port["is_smartnic"] != true {
+		t.Errorf("port was not updated: %v", port)
+	}
+	if d.Get("port_group_uuid") != port["portgroup_uuid"] || d.Get("is_smart_nic") != true {
+		t.Errorf("expected the updated port to be read back, got %v", d.State())
+	}
+
+	imported := r.Data(nil)
+	imported.SetId("52:54:00:cf:2d:32")
+	result, err := resourcePortV1Import(ctx, imported, clients)
+	th.AssertNoError(t, err)
+	if result[0].Id() != d.Id() {
+		t.Errorf("expected to import port %s by its MAC address, got %s", d.Id(), result[0].Id())
+	}
+
+	assertNoDiags(t, resourcePortV1Delete(ctx, d, clients))
+	if fake.Port(d.Id()) != nil {
+		t.Errorf("expected the port to be deleted")
+	}
+}
+
+func TestFakeIronic_allocation(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceAllocationV1()
+	nodeUUID := fake.CreateNode(map[string]interface{}{
+		"driver":          "fake-hardware",
+		"provision_state": "available",
+		"resource_class":  "baremetal",
+		"traits":          []interface{}{"CUSTOM_GPU"},
+	})
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"resource_class": "baremetal",
+		"traits":         []interface{}{"CUSTOM_GPU"},
+	})
+	assertNoDiags(t, resourceAllocationV1Create(ctx, d, clients))
+
+	if d.Get("node_uuid") != nodeUUID || fake.Node(nodeUUID)["allocation_uuid"] != d.Id() {
+		t.Errorf("expected node %s to be allocated, got '%s'", nodeUUID, d.Get("node_uuid"))
+	}
+
+	assertNoDiags(t, resourceAllocationV1Delete(ctx, d, clients))
+	if fake.Node(nodeUUID)["allocation_uuid"] != nil {
+		t.Errorf("expected the node to be released")
+	}
+
+	d = schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"resource_class": "compute",
+	})
+	diags := resourceAllocationV1Create(ctx, d, clients)
+	if !diags.HasError() || d.Id() != "" {
+		t.Errorf("expected the allocation to fail when no nodes match, got %v", diags)
+	}
+}
+
+func TestFakeIronic_deployment(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceDeployment()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "available"})
+
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"node_uuid": nodeUUID,
+		"instance_info": map[string]interface{}{
+			"image_source":   "http://example.com/image.qcow2",
+			"image_checksum": "26c53f3beca4e0b02e09d335257826fd",
+			"capabilities":   "boot_mode:uefi",
+		},
+		"user_data": "#cloud-config",
+	})
+	assertNoDiags(t, resourceDeploymentCreate(ctx, d, clients))
+
+	node := fake.Node(nodeUUID)
+	if node["provision_state"] != "active" || d.Get("provision_state") != "active" {
+		t.Errorf("expected the node to be active, got '%s'", node["provision_state"])
+	}
+	instanceInfo := node["instance_info"].(map[string]interface{})
+	if instanceInfo["configdrive"] == nil {
+		t.Errorf("expected a config drive to be sent to Ironic")
+	}
+	if capabilities := d.Get("instance_info.capabilities"); capabilities != "boot_mode:uefi" {
+		t.Errorf("expected capabilities to be read back, got '%s'", capabilities)
+	}
+
+	assertNoDiags(t, resourceDeploymentDelete(ctx, d, clients))
+	if state := fake.Node(nodeUUID)["provision_state"]; state != "available" {
+		t.Errorf("expected the node to be available after undeploying, got '%s'", state)
+	}
+}
+
+func TestFakeIronic_deploymentRebuild(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceDeployment()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "available"})
+
+	config := func(image string, redeploy bool) map[string]interface{} {
+		return map[string]interface{}{
+			"node_uuid":                   nodeUUID,
+			"instance_info":               map[string]interface{}{"image_source": image},
+			"user_data":                   "#cloud-config",
+			"redeploy_on_rebuild_failure": redeploy,
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config("http://example.com/v1.qcow2", false))
+	assertNoDiags(t, resourceDeploymentCreate(ctx, d, clients))
+
+	d = fakeUpdateData(t, r, d, clients, config("http://example.com/v2.qcow2", false))
+	assertNoDiags(t, resourceDeploymentUpdate(ctx, d, clients))
+	node := fake.Node(nodeUUID)
+	if targets := fake.ProvisionTargets(nodeUUID); fmt.Sprint(targets) != "[active rebuild]" {
+		t.Errorf("expected the node to be rebuilt in place, but the requests were %v", targets)
+	}
+	instanceInfo := node["instance_info"].(map[string]interface{})
+	if instanceInfo["image_source"] != "http://example.com/v2.qcow2" || instanceInfo["configdrive"] == nil {
+		t.Errorf("expected the new image and a config drive to be sent to Ironic, got %v", instanceInfo)
+	}
+
+	// When rebuilding keeps failing, the node is undeployed and deployed again
+	for i := 0; i <= maxRetryNumber; i++ {
+		fake.FailProvision(nodeUUID, "rebuild", "deployment failed")
+	}
+	d = fakeUpdateData(t, r, d, clients, config("http://example.com/v3.qcow2", true))
+	assertNoDiags(t, resourceDeploymentUpdate(ctx, d, clients))
+	if targets := fake.ProvisionTargets(nodeUUID); fmt.Sprint(targets[len(targets)-2:]) != "[deleted active]" {
+		t.Errorf("expected the node to be redeployed, but the requests were %v", targets)
+	}
+	if state := fake.Node(nodeUUID)["provision_state"]; state != "active" {
+		t.Errorf("expected the node to be active, got '%s'", state)
+	}
+
+	// Before API version 1.35 Ironic can't rebuild with a config drive
+	clients.ironic.Microversion = "1.34"
+	instanceInfo = fake.Node(nodeUUID)["instance_info"].(map[string]interface{})
+	d = fakeUpdateData(t, r, d, clients, config("http://example.com/v4.qcow2", false))
+	diags := resourceDeploymentUpdate(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "requires Ironic API version 1.35") {
+		t.Errorf("expected rebuilding to require API version 1.35, got %v", diags)
+	}
+	if info := fake.Node(nodeUUID)["instance_info"]; fmt.Sprint(info) != fmt.Sprint(instanceInfo) {
+		t.Errorf("expected the instance info to be left alone, got %v", info)
+	}
+}
+
+func TestFakeIronic_deploymentRescue(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceDeployment()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "available"})
+
+	config := func(rescue bool, password string) map[string]interface{} {
+		return map[string]interface{}{
+			"node_uuid":       nodeUUID,
+			"instance_info":   map[string]interface{}{"image_source": "http://example.com/image.qcow2"},
+			"rescue":          rescue,
+			"rescue_password": password,
+		}
+	}
+
+	d := schema.TestResourceDataRaw(t, r.Schema, config(false, ""))
+	assertNoDiags(t, resourceDeploymentCreate(ctx, d, clients))
+
+	d = fakeUpdateData(t, r, d, clients, config(true, "secret"))
+	assertNoDiags(t, resourceDeploymentUpdate(ctx, d, clients))
+	if state := fake.Node(nodeUUID)["provision_state"]; state != "rescue" || d.Get("rescue") != true {
+		t.Fatalf("expected the node to be rescued, got '%s'", state)
+	}
+	if password := fake.Node(nodeUUID)["instance_info"].(map[string]interface{})["rescue_password"]; password != "secret" {
+		t.Errorf("expected the rescue password to be sent to Ironic, got '%v'", password)
+	}
+
+	// Changing the password rescues the node again
+	d = fakeUpdateData(t, r, d, clients, config(true, "rotated"))
+	assertNoDiags(t, resourceDeploymentUpdate(ctx, d, clients))
+	if targets := fake.ProvisionTargets(nodeUUID); fmt.Sprint(targets) != "[active rescue unrescue rescue]" {
+		t.Errorf("expected the node to be rescued again, but the requests were %v", targets)
+	}
+
+	d = fakeUpdateData(t, r, d, clients, config(false, "rotated"))
+	assertNoDiags(t, resourceDeploymentUpdate(ctx, d, clients))
+	if state := fake.Node(nodeUUID)["provision_state"]; state != "active" || d.Get("rescue") != false {
+		t.Errorf("expected the node to be unrescued, got '%s'", state)
+	}
+
+	// Rescuing without a password is refused when planning
+	_, err := r.Diff(ctx, d.State(), terraform.NewResourceConfigRaw(config(true, "")), clients)
+	th.AssertError(t, err, "rescue_password is required")
+
+	// Before API version 1.38 Ironic can't rescue nodes
+	clients.ironic.Microversion = "1.37"
+	d = fakeUpdateData(t, r, d, clients, config(true, "secret"))
+	diags := resourceDeploymentUpdate(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "rescue requires Ironic API version 1.38") {
+		t.Errorf("expected rescuing to require API version 1.38, got %v", diags)
+	}
+}
+
+func TestFakeIronic_deploymentRetryPolicy(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	r := resourceDeployment()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "available"})
+	fake.FailProvision(nodeUUID, "active", "deployment failed")
+
+	// The provider would retry the deployment, the resource's policy gives up right away
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"node_uuid": nodeUUID,
+		"instance_info": map[string]interface{}{
+			"image_source": "http://example.com/image.qcow2",
+		},
+		"retry": []interface{}{
+			map[string]interface{}{"max_retries": 0, "maintenance_on_failure": true},
+		},
+	})
+	diags := resourceDeploymentCreate(ctx, d, clients)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "deploy failed") {
+		t.Fatalf("expected the deployment to fail, got %v", diags)
+	}
+
+	node := fake.Node(nodeUUID)
+	if targets := fake.ProvisionTargets(nodeUUID); fmt.Sprint(targets) != "[active]" {
+		t.Errorf("expected the deployment not to be retried, but the requests were %v", targets)
+	}
+	if node["maintenance"] != true || !strings.Contains(fmt.Sprint(node["maintenance_reason"]), "gave up on reaching target 'active'") {
+		t.Errorf("expected the node to be put in maintenance, got %v (reason: %v)", node["maintenance"], node["maintenance_reason"])
+	}
+}
+
+func TestFakeIronic_introspection(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "manageable"})
+	fake.SetIntrospectionData(nodeUUID, map[string]interface{}{
+		"cpu_arch":  "x86_64",
+		"cpus":      8,
+		"memory_mb": 16384,
+		"all_interfaces": map[string]interface{}{
+			"eth0": map[string]interface{}{"mac": "52:54:00:cf:2d:31", "ip": "192.168.111.20"},
+		},
+	})
+
+	client, err := clients.GetIronicClient()
+	th.AssertNoError(t, err)
+	th.AssertNoError(t, ChangeProvisionStateToTarget(ctx, client, nodeUUID, "inspect", nil, nil, nil, clients.workflowOptions()...))
+
+	d := schema.TestResourceDataRaw(t, dataSourceIronicIntrospection().Schema, map[string]interface{}{
+		"uuid": nodeUUID,
+	})
+	assertNoDiags(t, dataSourceIronicIntrospectionRead(ctx, d, clients))
+
+	if d.Get("finished") != true || d.Get("cpu_count") != 8 || d.Get("memory_mb") != 16384 || d.Get("interfaces.0.mac") != "52:54:00:cf:2d:31" {
+		t.Errorf("introspection data was not read: %v", d.State().Attributes)
+	}
+}
+
+func TestFakeIronic_nodeBIOSSettings(t *testing.T) {
+	fake, clients := newFakeClients(t)
+	ctx := context.Background()
+	nodeUUID := fake.CreateNode(map[string]interface{}{"driver": "idrac", "bios_interface": "idrac-redfish"})
+	fake.SetBIOSSettings(nodeUUID, map[string]string{"BootMode": "Uefi", "SerialNumber": "CN7475"})
+	fake.SetBIOSSettingDetails(nodeUUID, "BootMode", map[string]interface{}{
+		"attribute_type":   "Enumeration",
+		"allowable_values": []string{"Bios", "Uefi"},
+		"reset_required":   true,
+	})
+	fake.SetBIOSSettingDetails(nodeUUID, "SerialNumber", map[string]interface{}{
+		"attribute_type": "String",
+		"max_length":     16,
+		"read_only":      true,
+		"unique":         true,
+	})
+
+	d := schema.TestResourceDataRaw(t, dataSourceIronicNodeBIOSSettings().Schema, map[string]interface{}{
+		"node_uuid": nodeUUID,
+	})
+	assertNoDiags(t, dataSourceIronicNodeBIOSSettingsRead(ctx, d, clients))
+
+	if d.Get("settings.#") != 2 {
+		t.Fatalf("expected 2 settings, got %v", d.Get("settings"))
+	}
+	if d.Get("settings.0.name") != "BootMode" || d.Get("settings.0.value") != "Uefi" || d.Get("settings.0.attribute_type") != "Enumeration" ||
+		d.Get("settings.0.allowable_values.1") != "Uefi" || d.Get("settings.0.reset_required") != true || d.Get("settings.0.read_only") != false {
+		t.Errorf("unexpected details of the BootMode setting: %v", d.Get("settings.0"))
+	}
+	if d.Get("settings.1.name") != "SerialNumber" || d.Get("settings.1.max_length") != 16 || d.Get("settings.1.read_only") != true ||
+		d.Get("settings.1.unique") != true {
+		t.Errorf("unexpected details of the SerialNumber setting: %v", d.Get("settings.1"))
+	}
+
+	// Before API version 1.74 Ironic only knows the values
+	clients.ironic.Microversion = "1.65"
+	assertNoDiags(t, dataSourceIronicNodeBIOSSettingsRead(ctx, d, clients))
+	if d.Get("settings.1.value") != "CN7475" || d.Get("settings.1.read_only") != false {
+		t.Errorf("expected only the values of the settings, got %v", d.Get("settings.1"))
+	}
+}
+
+// newFakeClients starts a fake Ironic and returns the provider's clients for it, configured to poll without delay
+func newFakeClients(t *testing.T) (*th.FakeIronic, *Clients) {
+	fake := th.NewFakeIronic()
+	t.Cleanup(fake.Close)
+
+	p := Provider()
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"url":          fake.IronicURL(),
+		"inspector":    fake.InspectorURL(),
+		"microversion": "auto",
+	}))
+	assertNoDiags(t, diags)
+
+	clients := p.Meta().(*Clients)
+	clients.pollInterval = time.Millisecond
+	return fake, clients
+}
+
+// fakeUpdateData returns the resource data terraform would pass to Update, to go from the resource's current state
+// to the given configuration
+func fakeUpdateData(t *testing.T, r *schema.Resource, d *schema.ResourceData, meta interface{}, raw map[string]interface{}) *schema.ResourceData {
+	t.Helper()
+
+	state := d.State()
+	diff, err := r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), meta)
+	th.AssertNoError(t, err)
+
+	data, err := schema.InternalMap(r.Schema).Data(state, diff)
+	th.AssertNoError(t, err)
+	return data
+}
+
+func assertNoDiags(t *testing.T, diags diag.Diagnostics) {
+	t.Helper()
+
+	if diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+}
@@ -0,0 +1,38 @@
+package ironic
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// The version of gophercloud we use doesn't support the node maintenance API, so these call it directly.
+
+// setNodeMaintenance puts a node in maintenance mode, or changes the reason of a node already in maintenance
+func setNodeMaintenance(client *gophercloud.ServiceClient, nodeUUID, reason string) error {
+	body := map[string]interface{}{}
+	if reason != "" {
+		body["reason"] = reason
+	}
+
+	_, err := client.Put(client.ServiceURL("nodes", nodeUUID, "maintenance"), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	if err != nil {
+		return fmt.Errorf("could not put node %s in maintenance: %s", nodeUUID, err)
+	}
+
+	return nil
+}
+
+// unsetNodeMaintenance takes a node out of maintenance mode
+func unsetNodeMaintenance(client *gophercloud.ServiceClient, nodeUUID string) error {
+	_, err := client.Delete(client.ServiceURL("nodes", nodeUUID, "maintenance"), &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	if err != nil {
+		return fmt.Errorf("could not take node %s out of maintenance: %s", nodeUUID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package ironic
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gophercloud/gophercloud"
+	version "github.com/hashicorp/go-version"
+)
+
+const (
+	// autoMicroversion asks the provider to negotiate the microversion with Ironic
+	autoMicroversion = "auto"
+
+	// maxMicroversion is the newest Ironic API version the provider knows how to use
+	maxMicroversion = "1.81"
+)
+
+// Minimum Ironic API versions required by individual features
+const (
+	microversionAdopt           = "1.17"
+	microversionRebuildConfig   = "1.35"
+	microversionTraits          = "1.37"
+	microversionRescue          = "1.38"
+	microversionAllocations     = "1.52"
+	microversionConfigDriveJSON = "1.56"
+	microversionDeploySteps     = "1.69"
+	microversionBIOSDetail      = "1.74"
+)
+
+// negotiateMicroversion queries Ironic's version document and picks the highest version supported by both the
+// server and the provider.
+func negotiateMicroversion(client *gophercloud.ServiceClient) (string, error) {
+	var body struct {
+		Version struct {
+			Version    string `json:"version"`
+			MinVersion string `json:"min_version"`
+		} `json:"version"`
+	}
+
+	resp, err := client.Get(client.Endpoint, &body, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not get Ironic API version: %w", err)
+	}
+
+	// The headers are present on every response, the version document only on newer releases
+	serverMax := resp.Header.Get("X-OpenStack-Ironic-API-Maximum-Version")
+	if serverMax == "" {
+		serverMax = body.Version.Version
+	}
+	serverMin := resp.Header.Get("X-OpenStack-Ironic-API-Minimum-Version")
+	if serverMin == "" {
+		serverMin = body.Version.MinVersion
+	}
+	if serverMax == "" {
+		return "", fmt.Errorf("could not determine the maximum Ironic API version")
+	}
+
+	selected := maxMicroversion
+	if newer, err := versionAtLeast(maxMicroversion, serverMax); err != nil {
+		return "", err
+	} else if newer {
+		selected = serverMax
+	}
+
+	if serverMin != "" {
+		if ok, err := versionAtLeast(selected, serverMin); err != nil {
+			return "", err
+		} else if !ok {
+			return "", fmt.Errorf("Ironic requires API version %s or newer, but the provider supports up to %s", serverMin, maxMicroversion)
+		}
+	}
+
+	log.Printf("[INFO] Negotiated Ironic API version %s (server supports %s to %s)", selected, serverMin, serverMax)
+	return selected, nil
+}
+
+// versionAtLeast returns whether the actual API version is at least the minimum one.
+func versionAtLeast(actual, minimum string) (bool, error) {
+	a, err := version.NewVersion(actual)
+	if err != nil {
+		return false, err
+	}
+	m, err := version.NewVersion(minimum)
+	if err != nil {
+		return false, err
+	}
+
+	return !m.GreaterThan(a), nil
+}
+
+// requireMicroversion returns an error when the client's API version is older than what the feature requires.
+func requireMicroversion(client *gophercloud.ServiceClient, minimum, feature string) error {
+	if client.Microversion != "" {
+		if ok, err := versionAtLeast(client.Microversion, minimum); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s requires Ironic API version %s or newer, but version '%s' is in use", feature, minimum, client.Microversion)
+}
@@ -0,0 +1,125 @@
+package ironic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	gth "github.com/gophercloud/gophercloud/testhelper"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	th "github.com/openshift-metal3/terraform-provider-ironic/testhelper"
+)
+
+func TestNegotiateMicroversion(t *testing.T) {
+	testCases := []struct {
+		Scenario      string
+		ServerMin     string
+		ServerMax     string
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Scenario:  "older server",
+			ServerMin: "1.1",
+			ServerMax: "1.58",
+			Expected:  "1.58",
+		},
+		{
+			Scenario:  "newer server",
+			ServerMin: "1.1",
+			ServerMax: "1.99",
+			Expected:  maxMicroversion,
+		},
+		{
+			Scenario:      "server dropped support for old versions",
+			ServerMin:     "1.90",
+			ServerMax:     "1.99",
+			ExpectedError: "Ironic requires API version 1.90 or newer",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			gth.SetupHTTP()
+			defer gth.TeardownHTTP()
+			handleVersionRequest(t, tc.ServerMin, tc.ServerMax)
+
+			client := &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{},
+				Endpoint:       gth.Endpoint() + "v1/",
+			}
+			microversion, err := negotiateMicroversion(client)
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+				return
+			}
+			th.AssertNoError(t, err)
+			if microversion != tc.Expected {
+				t.Errorf("expected microversion %s, got %s", tc.Expected, microversion)
+			}
+		})
+	}
+}
+
+func TestProvider_autoMicroversion(t *testing.T) {
+	gth.SetupHTTP()
+	defer gth.TeardownHTTP()
+	handleVersionRequest(t, "1.1", "1.60")
+
+	p := Provider()
+	raw := map[string]interface{}{
+		"url":          gth.Endpoint() + "v1/",
+		"microversion": "auto",
+	}
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	client, err := p.Meta().(*Clients).GetIronicClient()
+	th.AssertNoError(t, err)
+	if client.Microversion != "1.60" {
+		t.Errorf("expected microversion 1.60, got %s", client.Microversion)
+	}
+
+	th.AssertNoError(t, requireMicroversion(client, microversionConfigDriveJSON, "config drive"))
+	th.AssertError(t, requireMicroversion(client, microversionDeploySteps, "deploy_steps"),
+		"deploy_steps requires Ironic API version 1.69 or newer, but version '1.60' is in use")
+}
+
+func TestDataSourceIronicAPIVersion(t *testing.T) {
+	gth.SetupHTTP()
+	defer gth.TeardownHTTP()
+	handleVersionRequest(t, "1.1", "1.60")
+
+	p := Provider()
+	raw := map[string]interface{}{
+		"url":          gth.Endpoint() + "v1/",
+		"microversion": "auto",
+	}
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	d := dataSourceIronicAPIVersion().Data(nil)
+	diags = dataSourceIronicAPIVersionRead(context.Background(), d, p.Meta())
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if microversion := d.Get("microversion"); microversion != "1.60" {
+		t.Errorf("expected the negotiated microversion 1.60, got %s", microversion)
+	}
+}
+
+func handleVersionRequest(t *testing.T, minVersion, maxVersion string) {
+	gth.Mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		gth.TestMethod(t, r, "GET")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-OpenStack-Ironic-API-Minimum-Version", minVersion)
+		w.Header().Set("X-OpenStack-Ironic-API-Maximum-Version", maxVersion)
+		fmt.Fprintf(w, `{"id": "v1", "version": {"id": "v1", "version": "%s", "min_version": "%s"}}`, maxVersion, minVersion)
+	})
+}
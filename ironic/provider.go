@@ -2,22 +2,30 @@ package ironic
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/httpbasic"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/noauth"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/drivers"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	httpbasicintrospection "github.com/gophercloud/gophercloud/openstack/baremetalintrospection/httpbasic"
 	noauthintrospection "github.com/gophercloud/gophercloud/openstack/baremetalintrospection/noauth"
 	"github.com/gophercloud/gophercloud/pagination"
+	"github.com/gophercloud/utils/openstack/clientconfig"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/openshift-metal3/terraform-provider-ironic/statemachine"
 )
 
 // Clients stores the client connection information for Ironic and Inspector
@@ -45,6 +53,31 @@ type Clients struct {
 	// resources calling out to the API.
 	inspectorMux sync.Mutex
 
+	// Boolean that determines if the microversion should be negotiated with Ironic on first use, rather than using
+	// the configured one.
+	negotiateMicroversion bool
+
+	// Boolean that determines if Ironic's operations are aborted when the user interrupts terraform.
+	abortOnCancel bool
+
+	// Boolean that determines if the provisioning workflow may change the provision state of nodes in maintenance.
+	allowMaintenance bool
+
+	// How long a node may stay in each provision state before the workflow considers it stalled, and whether it then
+	// aborts Ironic's operation.
+	stallTimeouts map[nodes.ProvisionState]time.Duration
+	abortOnStall  bool
+
+	// How the provisioning workflow retries failed requests, unless a resource has its own policy.
+	retryPolicy RetryPolicy
+
+	// Limits how many provisioning and power operations run at once.
+	operationLimiter *OperationLimiter
+
+	// How often to poll Ironic while waiting for an operation, the workflow's default is used when zero. Tests use
+	// this to avoid waiting on the fake Ironic.
+	pollInterval time.Duration
+
 	timeout int
 }
 
@@ -58,7 +91,7 @@ func (c *Clients) GetIronicClient() (*gophercloud.ServiceClient, error) {
 
 	// Ironic is UP, or user didn't ask us to check
 	if c.ironicUp || c.timeout == 0 {
-		return c.ironic, nil
+		return c.ironicWithMicroversion()
 	}
 
 	// We previously tried and it failed.
@@ -91,7 +124,23 @@ func (c *Clients) GetIronicClient() (*gophercloud.ServiceClient, error) {
 	}
 
 	c.ironicUp = true
-	return c.ironic, ctx.Err()
+	return c.ironicWithMicroversion()
+}
+
+// ironicWithMicroversion returns the Ironic client, negotiating the microversion first if the user asked for it.
+func (c *Clients) ironicWithMicroversion() (*gophercloud.ServiceClient, error) {
+	if !c.negotiateMicroversion {
+		return c.ironic, nil
+	}
+
+	microversion, err := negotiateMicroversion(c.ironic)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ironic.Microversion = microversion
+	c.negotiateMicroversion = false
+	return c.ironic, nil
 }
 
 // GetInspectorClient returns the API client for Ironic, optionally retrying to reach the API if timeout is set.
@@ -141,13 +190,28 @@ func (c *Clients) GetInspectorClient() (*gophercloud.ServiceClient, error) {
 	return c.inspector, ctx.Err()
 }
 
+// workflowOptions returns the provider-wide settings for the provisioning workflow.
+func (c *Clients) workflowOptions() []WorkflowOption {
+	options := []WorkflowOption{
+		WithAbortOnCancel(c.abortOnCancel),
+		WithAllowMaintenance(c.allowMaintenance),
+		WithStallTimeouts(c.stallTimeouts, c.abortOnStall),
+		WithRetryPolicy(c.retryPolicy),
+		WithOperationLimiter(c.operationLimiter),
+	}
+	if c.pollInterval != 0 {
+		options = append(options, WithPollInterval(c.pollInterval))
+	}
+	return options
+}
+
 // Provider Ironic
 func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"url": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("IRONIC_ENDPOINT", ""),
 				Description: descriptions["url"],
 			},
@@ -162,6 +226,10 @@ func Provider() *schema.Provider {
 				Required:    true,
 				DefaultFunc: schema.EnvDefaultFunc("IRONIC_MICROVERSION", "1.52"),
 				Description: descriptions["microversion"],
+				ValidateFunc: validation.StringMatch(
+					regexp.MustCompile(`^(auto|\d+\.\d+)$`),
+					"must be a version such as 1.52, or auto",
+				),
 			},
 			"timeout": {
 				Type:        schema.TypeInt,
@@ -169,13 +237,51 @@ func Provider() *schema.Provider {
 				Description: descriptions["timeout"],
 				Default:     0,
 			},
+			"abort_on_cancel": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["abort_on_cancel"],
+			},
+			"allow_maintenance": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["allow_maintenance"],
+			},
+			"stall_timeouts": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: descriptions["stall_timeouts"],
+			},
+			"abort_on_stall": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: descriptions["abort_on_stall"],
+			},
+			"retry": retrySchema(descriptions["retry"]),
+			"max_concurrent_operations": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  descriptions["max_concurrent_operations"],
+			},
+			"max_concurrent_operations_per_conductor_group": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Description: descriptions["max_concurrent_operations_per_conductor_group"],
+			},
 			"auth_strategy": {
 				Type:        schema.TypeString,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("IRONIC_AUTH_STRATEGY", "noauth"),
 				Description: descriptions["auth_strategy"],
 				ValidateFunc: validation.StringInSlice([]string{
-					"noauth", "http_basic",
+					"noauth", "http_basic", "keystone",
 				}, false),
 			},
 			"ironic_username": {
@@ -204,6 +310,114 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("INSPECTOR_HTTP_BASIC_PASSWORD", ""),
 				Description: descriptions["inspector_username"],
 			},
+			"cacert_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("IRONIC_CACERT_FILE", ""),
+				Description: descriptions["cacert_file"],
+			},
+			"cert": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("IRONIC_CERT", ""),
+				Description: descriptions["cert"],
+			},
+			"key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("IRONIC_KEY", ""),
+				Description: descriptions["key"],
+			},
+			"insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("IRONIC_INSECURE", false),
+				Description: descriptions["insecure"],
+			},
+			"cloud": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_CLOUD", ""),
+				Description: descriptions["cloud"],
+			},
+			"auth_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_AUTH_URL", ""),
+				Description: descriptions["auth_url"],
+			},
+			"user_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_USERNAME", ""),
+				Description: descriptions["user_name"],
+			},
+			"password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_PASSWORD", ""),
+				Description: descriptions["password"],
+			},
+			"user_domain_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_USER_DOMAIN_NAME", ""),
+				Description: descriptions["user_domain_name"],
+			},
+			"project_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_PROJECT_NAME", ""),
+				Description: descriptions["project_name"],
+			},
+			"project_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_PROJECT_ID", ""),
+				Description: descriptions["project_id"],
+			},
+			"project_domain_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_PROJECT_DOMAIN_NAME", ""),
+				Description: descriptions["project_domain_name"],
+			},
+			"application_credential_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_APPLICATION_CREDENTIAL_ID", ""),
+				Description: descriptions["application_credential_id"],
+			},
+			"application_credential_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_APPLICATION_CREDENTIAL_NAME", ""),
+				Description: descriptions["application_credential_name"],
+			},
+			"application_credential_secret": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_APPLICATION_CREDENTIAL_SECRET", ""),
+				Description: descriptions["application_credential_secret"],
+			},
+			"region": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_REGION_NAME", ""),
+				Description: descriptions["region"],
+			},
+			"interface": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("OS_INTERFACE", ""),
+				Description: descriptions["interface"],
+				ValidateFunc: validation.StringInSlice([]string{
+					"", "public", "internal", "admin",
+				}, false),
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"ironic_node_v1":       resourceNodeV1(),
@@ -212,7 +426,9 @@ func Provider() *schema.Provider {
 			"ironic_deployment":    resourceDeployment(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
-			"ironic_introspection": dataSourceIronicIntrospection(),
+			"ironic_api_version":        dataSourceIronicAPIVersion(),
+			"ironic_introspection":      dataSourceIronicIntrospection(),
+			"ironic_node_bios_settings": dataSourceIronicNodeBIOSSettings(),
 		},
 		ConfigureFunc: configureProvider,
 	}
@@ -224,13 +440,40 @@ func init() {
 	descriptions = map[string]string{
 		"url":                "The authentication endpoint for Ironic",
 		"inspector":          "The endpoint for Ironic inspector",
-		"microversion":       "The microversion to use for Ironic",
+		"microversion":       "The microversion to use for Ironic, or `auto` to use the newest version supported by both Ironic and the provider. The version in use is available from the `ironic_api_version` data source",
 		"timeout":            "Wait at least the specified number of seconds for the API to become available",
-		"auth_strategy":      "Determine the strategy to use for authentication with Ironic services, Possible values: noauth, http_basic. Defaults to noauth.",
+		"abort_on_cancel":    "Abort Ironic's cleaning, inspection or rescue of a node when terraform is interrupted",
+		"allow_maintenance":  "Allow changing the provision state of nodes in maintenance mode, which is refused by default",
+		"stall_timeouts":     "How long a node may stay in a provision state, e.g. `clean wait = \"30m\"`, before it is considered stalled and waiting for it fails",
+		"abort_on_stall":     "Abort Ironic's operation on a stalled node, when its provision state allows it. A deployment stalled in `wait call-back` is undeployed",
+		"retry":              "How failed cleaning, inspection, deployment, etc. are retried, unless a resource sets its own policy",
+		"auth_strategy":      "Determine the strategy to use for authentication with Ironic services, Possible values: noauth, http_basic, keystone. Defaults to noauth.",
 		"ironic_username":    "Username to be used by Ironic when using `http_basic` authentication",
 		"ironic_password":    "Password to be used by Ironic when using `http_basic` authentication",
 		"inspector_username": "Username to be used by Ironic Inspector when using `http_basic` authentication",
 		"inspector_password": "Password to be used by Ironic Inspector when using `http_basic` authentication",
+
+		"cacert_file": "Custom CA bundle used to verify the Ironic and Inspector endpoints, either a file path or PEM encoded contents",
+		"cert":        "Client certificate for mutual TLS with Ironic and Inspector, either a file path or PEM encoded contents",
+		"key":         "Client key for mutual TLS with Ironic and Inspector, either a file path or PEM encoded contents",
+		"insecure":    "Skip verification of the Ironic and Inspector certificates",
+
+		"cloud":                         "Name of the cloud in clouds.yaml to use when using `keystone` authentication",
+		"auth_url":                      "The Identity endpoint to use when using `keystone` authentication",
+		"user_name":                     "Username to authenticate with when using `keystone` authentication",
+		"password":                      "Password to authenticate with when using `keystone` authentication",
+		"user_domain_name":              "Domain name of the user when using `keystone` authentication",
+		"project_name":                  "Name of the project to scope to when using `keystone` authentication",
+		"project_id":                    "ID of the project to scope to when using `keystone` authentication",
+		"project_domain_name":           "Domain name of the project when using `keystone` authentication",
+		"application_credential_id":     "ID of the application credential to authenticate with when using `keystone` authentication",
+		"application_credential_name":   "Name of the application credential to authenticate with when using `keystone` authentication",
+		"application_credential_secret": "Secret of the application credential to authenticate with when using `keystone` authentication",
+		"region":                        "Region of the Ironic and Inspector endpoints in the service catalog",
+		"interface":                     "Interface of the Ironic and Inspector endpoints in the service catalog, Possible values: public, internal, admin. Defaults to public.",
+
+		"max_concurrent_operations":                     "How many provisioning and power operations may run at once across all resources, to keep a large apply from overwhelming the conductors, image server and DHCP. Unlimited by default",
+		"max_concurrent_operations_per_conductor_group": "How many provisioning and power operations may run at once on the nodes of each conductor group, e.g. `rack-1 = 4`",
 	}
 }
 
@@ -239,14 +482,24 @@ func configureProvider(schema *schema.ResourceData) (interface{}, error) {
 	var clients Clients
 
 	url := schema.Get("url").(string)
-	if url == "" {
+	authStrategy := schema.Get("auth_strategy").(string)
+	if url == "" && authStrategy != "keystone" {
 		return nil, fmt.Errorf("url is required for ironic provider")
 	}
 	log.Printf("[DEBUG] Ironic endpoint is %s", url)
 
-	authStrategy := schema.Get("auth_strategy").(string)
+	httpClient, err := buildHTTPClient(schema)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure TLS: %w", err)
+	}
+
+	if authStrategy == "keystone" {
+		log.Printf("[DEBUG] Using keystone auth_strategy")
 
-	if authStrategy == "http_basic" {
+		if err := configureKeystone(schema, &clients, httpClient); err != nil {
+			return nil, err
+		}
+	} else if authStrategy == "http_basic" {
 		log.Printf("[DEBUG] Using http_basic auth_strategy")
 
 		ironicUser := schema.Get("ironic_username").(string)
@@ -261,7 +514,8 @@ func configureProvider(schema *schema.ResourceData) (interface{}, error) {
 			return nil, err
 		}
 
-		ironic.Microversion = schema.Get("microversion").(string)
+		ironic.HTTPClient = httpClient
+		setMicroversion(schema, &clients, ironic)
 		clients.ironic = ironic
 
 		inspectorURL := schema.Get("inspector").(string)
@@ -279,6 +533,7 @@ func configureProvider(schema *schema.ResourceData) (interface{}, error) {
 			if err != nil {
 				return nil, err
 			}
+			inspector.HTTPClient = httpClient
 			clients.inspector = inspector
 		}
 
@@ -290,7 +545,8 @@ func configureProvider(schema *schema.ResourceData) (interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		ironic.Microversion = schema.Get("microversion").(string)
+		ironic.HTTPClient = httpClient
+		setMicroversion(schema, &clients, ironic)
 		clients.ironic = ironic
 
 		inspectorURL := schema.Get("inspector").(string)
@@ -302,20 +558,239 @@ func configureProvider(schema *schema.ResourceData) (interface{}, error) {
 			if err != nil {
 				return nil, fmt.Errorf("could not configure inspector endpoint: %s", err.Error())
 			}
+			inspector.HTTPClient = httpClient
 			clients.inspector = inspector
 		}
 
 	}
 
 	clients.timeout = schema.Get("timeout").(int)
+	clients.abortOnCancel = schema.Get("abort_on_cancel").(bool)
+	clients.allowMaintenance = schema.Get("allow_maintenance").(bool)
+	clients.abortOnStall = schema.Get("abort_on_stall").(bool)
+
+	clients.stallTimeouts = make(map[nodes.ProvisionState]time.Duration)
+	for state, raw := range schema.Get("stall_timeouts").(map[string]interface{}) {
+		if !statemachine.IsTransient(nodes.ProvisionState(state)) {
+			return nil, fmt.Errorf("invalid stall timeout for state '%s': Ironic doesn't work on nodes in that state", state)
+		}
+		timeout, err := time.ParseDuration(raw.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid stall timeout for state '%s': %s", state, err)
+		}
+		clients.stallTimeouts[nodes.ProvisionState(state)] = timeout
+	}
+
+	clients.retryPolicy = defaultRetryPolicy
+	if policy, ok, err := retryPolicyFromConfig(schema.Get("retry").([]interface{})); err != nil {
+		return nil, err
+	} else if ok {
+		clients.retryPolicy = policy
+	}
+
+	perGroup := make(map[string]int)
+	for group, raw := range schema.Get("max_concurrent_operations_per_conductor_group").(map[string]interface{}) {
+		limit := raw.(int)
+		if limit < 1 {
+			return nil, fmt.Errorf("invalid concurrent operations limit for conductor group '%s': it must be at least 1", group)
+		}
+		perGroup[group] = limit
+	}
+	clients.operationLimiter = NewOperationLimiter(schema.Get("max_concurrent_operations").(int), perGroup)
 
 	return &clients, nil
 }
 
+// Sets the configured microversion on the Ironic client, or defers to negotiating it on first use.
+func setMicroversion(schema *schema.ResourceData, clients *Clients, ironic *gophercloud.ServiceClient) {
+	microversion := schema.Get("microversion").(string)
+	if microversion == autoMicroversion {
+		clients.negotiateMicroversion = true
+		return
+	}
+
+	ironic.Microversion = microversion
+}
+
+// Authenticates against keystone, and discovers the Ironic and Inspector endpoints from the service catalog. The url
+// and inspector arguments, when set, override the endpoints found in the catalog.
+func configureKeystone(schema *schema.ResourceData, clients *Clients, httpClient http.Client) error {
+	opts := clientconfig.ClientOpts{
+		Cloud: schema.Get("cloud").(string),
+	}
+	if opts.Cloud == "" {
+		opts.AuthInfo = &clientconfig.AuthInfo{
+			AuthURL:                     schema.Get("auth_url").(string),
+			Username:                    schema.Get("user_name").(string),
+			Password:                    schema.Get("password").(string),
+			UserDomainName:              schema.Get("user_domain_name").(string),
+			ProjectName:                 schema.Get("project_name").(string),
+			ProjectID:                   schema.Get("project_id").(string),
+			ProjectDomainName:           schema.Get("project_domain_name").(string),
+			ApplicationCredentialID:     schema.Get("application_credential_id").(string),
+			ApplicationCredentialName:   schema.Get("application_credential_name").(string),
+			ApplicationCredentialSecret: schema.Get("application_credential_secret").(string),
+		}
+	}
+
+	ao, err := clientconfig.AuthOptions(&opts)
+	if err != nil {
+		return fmt.Errorf("could not build keystone credentials: %w", err)
+	}
+	ao.AllowReauth = true
+
+	// Region and interface may come from clouds.yaml, but are overridden by the provider's arguments
+	eo := gophercloud.EndpointOpts{
+		Region:       schema.Get("region").(string),
+		Availability: clientconfig.GetEndpointType(schema.Get("interface").(string)),
+	}
+	if opts.Cloud != "" {
+		cloud, err := clientconfig.GetCloudFromYAML(&opts)
+		if err != nil {
+			return err
+		}
+		if eo.Region == "" {
+			eo.Region = cloud.RegionName
+		}
+		if schema.Get("interface").(string) == "" {
+			eo.Availability = clientconfig.GetEndpointType(cloudInterface(&opts, cloud))
+		}
+	}
+
+	provider, err := openstack.NewClient(ao.IdentityEndpoint)
+	if err != nil {
+		return err
+	}
+	provider.HTTPClient = httpClient
+	if err := openstack.Authenticate(provider, *ao); err != nil {
+		return fmt.Errorf("could not authenticate with keystone: %w", err)
+	}
+
+	ironic, err := newCatalogClient(provider, eo, "baremetal")
+	if err != nil {
+		return fmt.Errorf("could not find ironic endpoint: %w", err)
+	}
+	if url := schema.Get("url").(string); url != "" {
+		ironic.Endpoint = gophercloud.NormalizeURL(url)
+	}
+	setMicroversion(schema, clients, ironic)
+	clients.ironic = ironic
+
+	inspector, err := newCatalogClient(provider, eo, "baremetal-introspection")
+	if inspectorURL := schema.Get("inspector").(string); inspectorURL != "" {
+		inspector.Endpoint = gophercloud.NormalizeURL(inspectorURL)
+	} else if err != nil {
+		// Inspector is optional, and not every cloud runs it
+		log.Printf("[DEBUG] No inspector endpoint found in the service catalog: %s", err)
+		return nil
+	}
+	log.Printf("[DEBUG] Inspector endpoint is %s", inspector.Endpoint)
+	clients.inspector = inspector
+
+	return nil
+}
+
+// Returns the interface of the cloud's endpoints. interface is the standard clouds.yaml key and takes precedence over
+// endpoint_type, but clientconfig merges both into EndpointType, preferring endpoint_type, so the entry is read again.
+func cloudInterface(opts *clientconfig.ClientOpts, cloud *clientconfig.Cloud) string {
+	if cloud.Interface != "" {
+		return cloud.Interface
+	}
+
+	clouds, err := opts.YAMLOpts.LoadCloudsYAML()
+	if err != nil {
+		return cloud.EndpointType
+	}
+	if raw, ok := clouds[opts.Cloud]; ok && raw.Interface != "" {
+		return raw.Interface
+	}
+	return cloud.EndpointType
+}
+
+// Builds a service client using the endpoint of the given service type from the catalog. Catalog entries for Ironic
+// and Inspector usually point at the root of the API, so the v1 prefix is added when it's missing. A client is
+// returned even if the service isn't found, so the caller can still set an explicit endpoint.
+func newCatalogClient(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, serviceType string) (*gophercloud.ServiceClient, error) {
+	client := &gophercloud.ServiceClient{
+		ProviderClient: provider,
+		Type:           serviceType,
+	}
+
+	eo.ApplyDefaults(serviceType)
+	endpoint, err := provider.EndpointLocator(eo)
+	if err != nil {
+		return client, err
+	}
+
+	endpoint = gophercloud.NormalizeURL(endpoint)
+	if !strings.HasSuffix(endpoint, "/v1/") {
+		endpoint += "v1/"
+	}
+	client.Endpoint = endpoint
+
+	return client, nil
+}
+
+// Builds the HTTP client shared by the Ironic and Inspector clients, configured with the provider's TLS settings.
+func buildHTTPClient(schema *schema.ResourceData) (http.Client, error) {
+	// disable "G402 (CWE-295): TLS MinVersion too low. (Confidence: HIGH, Severity: HIGH)"
+	// #nosec G402
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: schema.Get("insecure").(bool),
+	}
+
+	if caCert := schema.Get("cacert_file").(string); caCert != "" {
+		pem, err := readPEM(caCert)
+		if err != nil {
+			return http.Client{}, fmt.Errorf("could not read cacert_file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(pem) {
+			return http.Client{}, fmt.Errorf("no certificates found in cacert_file")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	cert, key := schema.Get("cert").(string), schema.Get("key").(string)
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return http.Client{}, fmt.Errorf("both cert and key are required for client certificate authentication")
+		}
+		certPEM, err := readPEM(cert)
+		if err != nil {
+			return http.Client{}, fmt.Errorf("could not read cert: %w", err)
+		}
+		keyPEM, err := readPEM(key)
+		if err != nil {
+			return http.Client{}, fmt.Errorf("could not read key: %w", err)
+		}
+		clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return http.Client{}, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return http.Client{Transport: transport}, nil
+}
+
+// Returns PEM data given either the PEM encoded contents themselves, or the path to a file containing them.
+func readPEM(value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	return os.ReadFile(value)
+}
+
 // Retries an API forever until it responds.
 func waitForAPI(ctx context.Context, client *gophercloud.ServiceClient) {
 	httpClient := &http.Client{
-		Timeout: 5 * time.Second,
+		Timeout:   5 * time.Second,
+		Transport: client.HTTPClient.Transport,
 	}
 
 	// NOTE: Some versions of Ironic inspector returns 404 for /v1/ but 200 for /v1,
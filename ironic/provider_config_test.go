@@ -0,0 +1,313 @@
+package ironic
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	gth "github.com/gophercloud/gophercloud/testhelper"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	th "github.com/openshift-metal3/terraform-provider-ironic/testhelper"
+)
+
+func TestProvider_keystone(t *testing.T) {
+	// The endpoints come from the catalog, unless overridden
+	t.Setenv("IRONIC_ENDPOINT", "")
+	t.Setenv("IRONIC_INSPECTOR_ENDPOINT", "")
+	p := Provider()
+
+	gth.SetupHTTP()
+	defer gth.TeardownHTTP()
+	handleKeystoneTokenRequest(t)
+
+	raw := map[string]interface{}{
+		"auth_strategy":       "keystone",
+		"auth_url":            gth.Endpoint() + "v3",
+		"user_name":           "admin",
+		"password":            "secret",
+		"user_domain_name":    "Default",
+		"project_name":        "admin",
+		"project_domain_name": "Default",
+		"region":              "RegionOne",
+		"interface":           "internal",
+	}
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	clients := p.Meta().(*Clients)
+	if expected := gth.Endpoint() + "baremetal-internal/v1/"; clients.ironic.Endpoint != expected {
+		t.Fatalf("expected ironic endpoint %s, got %s", expected, clients.ironic.Endpoint)
+	}
+	if expected := gth.Endpoint() + "inspector-internal/v1/"; clients.inspector.Endpoint != expected {
+		t.Fatalf("expected inspector endpoint %s, got %s", expected, clients.inspector.Endpoint)
+	}
+	if token := clients.ironic.TokenID; token != "fake-token" {
+		t.Fatalf("expected ironic client to use the keystone token, got '%s'", token)
+	}
+}
+
+func TestProvider_keystoneCloudsYAML(t *testing.T) {
+	t.Setenv("IRONIC_ENDPOINT", "")
+	t.Setenv("IRONIC_INSPECTOR_ENDPOINT", "")
+	t.Setenv("OS_INTERFACE", "")
+	t.Setenv("OS_CLOUD", "")
+
+	gth.SetupHTTP()
+	defer gth.TeardownHTTP()
+	handleKeystoneTokenRequest(t)
+
+	testCases := []struct {
+		Scenario string
+		Endpoint string
+		Expected string
+	}{
+		{
+			Scenario: "interface",
+			Endpoint: "interface: internal",
+			Expected: "baremetal-internal/v1/",
+		},
+		{
+			Scenario: "endpoint_type",
+			Endpoint: "endpoint_type: internal",
+			Expected: "baremetal-internal/v1/",
+		},
+		{
+			Scenario: "interface takes precedence",
+			Endpoint: "interface: internal\n    endpoint_type: public",
+			Expected: "baremetal-internal/v1/",
+		},
+		{
+			Scenario: "public by default",
+			Expected: "baremetal-public/v1/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			cloudsFile := t.TempDir() + "/clouds.yaml"
+			clouds := fmt.Sprintf(`clouds:
+  test:
+    auth:
+      auth_url: %sv3
+      username: admin
+      password: secret
+      user_domain_name: Default
+      project_name: admin
+      project_domain_name: Default
+    region_name: RegionOne
+    %s
+`, gth.Endpoint(), tc.Endpoint)
+			th.AssertNoError(t, os.WriteFile(cloudsFile, []byte(clouds), 0600))
+			t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsFile)
+
+			p := Provider()
+			raw := map[string]interface{}{
+				"auth_strategy": "keystone",
+				"cloud":         "test",
+			}
+			diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			if expected := gth.Endpoint() + tc.Expected; p.Meta().(*Clients).ironic.Endpoint != expected {
+				t.Errorf("expected ironic endpoint %s, got %s", expected, p.Meta().(*Clients).ironic.Endpoint)
+			}
+		})
+	}
+}
+
+func TestProvider_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"uuid": "e5b1b4a4-7d0e-4b5a-9f4c-2bfb1d3c7f10", "provision_state": "enroll"}`)
+	}))
+	defer server.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caFile := t.TempDir() + "/ca.pem"
+	th.AssertNoError(t, os.WriteFile(caFile, []byte(caCert), 0600))
+
+	testCases := []struct {
+		Scenario      string
+		Config        map[string]interface{}
+		ExpectedError string
+	}{
+		{
+			Scenario:      "untrusted certificate",
+			Config:        map[string]interface{}{},
+			ExpectedError: "certificate",
+		},
+		{
+			Scenario: "ca bundle as PEM",
+			Config:   map[string]interface{}{"cacert_file": caCert},
+		},
+		{
+			Scenario: "ca bundle as file",
+			Config:   map[string]interface{}{"cacert_file": caFile},
+		},
+		{
+			Scenario: "insecure",
+			Config:   map[string]interface{}{"insecure": true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			p := Provider()
+			tc.Config["url"] = server.URL + "/v1"
+			diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(tc.Config))
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			client, err := p.Meta().(*Clients).GetIronicClient()
+			th.AssertNoError(t, err)
+
+			_, err = nodes.Get(client, "e5b1b4a4-7d0e-4b5a-9f4c-2bfb1d3c7f10").Extract()
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+			} else {
+				th.AssertNoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProvider_clientCertRequiresKey(t *testing.T) {
+	p := Provider()
+	raw := map[string]interface{}{
+		"url":  "https://localhost:6385/v1",
+		"cert": "-----BEGIN CERTIFICATE-----",
+	}
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+	if !diags.HasError() {
+		t.Fatal("expected an error when cert is set without key")
+	}
+}
+
+func TestProvider_stallTimeouts(t *testing.T) {
+	cases := []struct {
+		Scenario      string
+		StallTimeouts map[string]interface{}
+		ExpectedError bool
+	}{
+		{
+			Scenario:      "valid",
+			StallTimeouts: map[string]interface{}{"clean wait": "30m", "wait call-back": "1h"},
+		},
+		{
+			Scenario:      "invalid duration",
+			StallTimeouts: map[string]interface{}{"clean wait": "soon"},
+			ExpectedError: true,
+		},
+		{
+			Scenario:      "state Ironic doesn't work in",
+			StallTimeouts: map[string]interface{}{"active": "30m"},
+			ExpectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			p := Provider()
+			raw := map[string]interface{}{
+				"url":            "http://localhost:6385/v1",
+				"stall_timeouts": c.StallTimeouts,
+			}
+			diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+			if diags.HasError() != c.ExpectedError {
+				t.Fatalf("unexpected diagnostics: %v", diags)
+			}
+			if !c.ExpectedError && p.Meta().(*Clients).stallTimeouts[nodes.CleanWait] != 30*time.Minute {
+				t.Errorf("expected the stall timeout to be parsed, got %v", p.Meta().(*Clients).stallTimeouts)
+			}
+		})
+	}
+}
+
+func TestProvider_maxConcurrentOperations(t *testing.T) {
+	cases := []struct {
+		Scenario      string
+		Max           int
+		PerGroup      map[string]interface{}
+		ExpectedError bool
+	}{
+		{
+			Scenario: "unlimited",
+		},
+		{
+			Scenario: "valid",
+			Max:      10,
+			PerGroup: map[string]interface{}{"rack-1": 4, "rack-2": 2},
+		},
+		{
+			Scenario:      "invalid conductor group limit",
+			PerGroup:      map[string]interface{}{"rack-1": 0},
+			ExpectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			p := Provider()
+			raw := map[string]interface{}{
+				"url":                       "http://localhost:6385/v1",
+				"max_concurrent_operations": c.Max,
+				"max_concurrent_operations_per_conductor_group": c.PerGroup,
+			}
+			diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(raw))
+			if diags.HasError() != c.ExpectedError {
+				t.Fatalf("unexpected diagnostics: %v", diags)
+			}
+			if c.ExpectedError {
+				return
+			}
+
+			limiter := p.Meta().(*Clients).operationLimiter
+			if cap(limiter.all) != c.Max || len(limiter.groups) != len(c.PerGroup) {
+				t.Errorf("expected a limit of %d and %d conductor groups, got %d and %v", c.Max, len(c.PerGroup), cap(limiter.all), limiter.groups)
+			}
+		})
+	}
+}
+
+func handleKeystoneTokenRequest(t *testing.T) {
+	gth.Mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		gth.TestMethod(t, r, "POST")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Subject-Token", "fake-token")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{
+			"token": {
+				"expires_at": "2100-01-01T00:00:00.000000Z",
+				"catalog": [
+					{
+						"type": "baremetal",
+						"name": "ironic",
+						"endpoints": [
+							{"interface": "public", "region": "RegionOne", "url": "%[1]sbaremetal-public"},
+							{"interface": "internal", "region": "RegionOne", "url": "%[1]sbaremetal-internal"}
+						]
+					},
+					{
+						"type": "baremetal-introspection",
+						"name": "ironic-inspector",
+						"endpoints": [
+							{"interface": "public", "region": "RegionOne", "url": "%[1]sinspector-public"},
+							{"interface": "internal", "region": "RegionOne", "url": "%[1]sinspector-internal/v1"}
+						]
+					}
+				]
+			}
+		}`, gth.Endpoint())
+	})
+}
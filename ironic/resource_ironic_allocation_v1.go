@@ -1,21 +1,30 @@
 package ironic
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/allocations"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 // Schema resource definition for an Ironic allocation.
 func resourceAllocationV1() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceAllocationV1Create,
-		Read:   resourceAllocationV1Read,
-		Delete: resourceAllocationV1Delete,
+		CreateContext: resourceAllocationV1Create,
+		ReadContext:   resourceAllocationV1Read,
+		DeleteContext: resourceAllocationV1Delete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceAllocationV1Import,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(1 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -67,43 +76,42 @@ func resourceAllocationV1() *schema.Resource {
 }
 
 // Create an allocation, including driving Ironic's state machine
-func resourceAllocationV1Create(d *schema.ResourceData, meta interface{}) error {
+func resourceAllocationV1Create(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+
+	if err := requireMicroversion(client, microversionAllocations, "ironic_allocation_v1"); err != nil {
+		return diag.FromErr(err)
 	}
 
 	result, err := allocations.Create(client, allocationSchemaToCreateOpts(d)).Extract()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	d.SetId(result.UUID)
 
-	// Wait for state to change from allocating
-	timeout := 1 * time.Minute
+	// Wait for state to change from allocating, until the create timeout is reached
 	checkInterval := 2 * time.Second
 
 	for {
-		err = resourceAllocationV1Read(d, meta)
-		if err != nil {
-			return err
+		if diags := resourceAllocationV1Read(ctx, d, meta); diags.HasError() {
+			return diags
 		}
 		state := d.Get("state").(string)
 		log.Printf("[DEBUG] Requested allocation %s; current state is '%s'\n", d.Id(), state)
 		switch state {
 		case "allocating":
-			time.Sleep(checkInterval)
-			checkInterval += 2
-			timeout -= checkInterval
-			if timeout < 0 {
-				return fmt.Errorf("timed out waiting for allocation")
+			if err := sleepWithContext(ctx, checkInterval); err != nil {
+				return diag.Errorf("timed out waiting for allocation: %s", err)
 			}
 		case "error":
 			err := d.Get("last_error").(string)
-			_ = resourceAllocationV1Delete(d, meta)
+			_ = resourceAllocationV1Delete(ctx, d, meta)
 			d.SetId("")
-			return fmt.Errorf("error creating resource: %s", err)
+			return diag.Errorf("error creating resource: %s", err)
 		default:
 			return nil
 		}
@@ -111,53 +119,69 @@ func resourceAllocationV1Create(d *schema.ResourceData, meta interface{}) error
 }
 
 // Read the allocation's data from Ironic
-func resourceAllocationV1Read(d *schema.ResourceData, meta interface{}) error {
+func resourceAllocationV1Read(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	result, err := allocations.Get(client, d.Id()).Extract()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	err = d.Set("name", result.Name)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("resource_class", result.ResourceClass)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("candidate_nodes", result.CandidateNodes)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("traits", result.Traits)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("extra", result.Extra)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("node_uuid", result.NodeUUID)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("state", result.State)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	return diag.FromErr(d.Set("last_error", result.LastError))
+}
+
+// Import an allocation by UUID or name
+func resourceAllocationV1Import(_ context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := allocations.Get(client, d.Id()).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("could not find allocation %s: %s", d.Id(), err)
 	}
-	return d.Set("last_error", result.LastError)
+	d.SetId(result.UUID)
+
+	return []*schema.ResourceData{d}, nil
 }
 
 // Delete an allocation from Ironic if it exists
-func resourceAllocationV1Delete(d *schema.ResourceData, meta interface{}) error {
+func resourceAllocationV1Delete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	_, err = allocations.Get(client, d.Id()).Extract()
@@ -165,7 +189,7 @@ func resourceAllocationV1Delete(d *schema.ResourceData, meta interface{}) error
 		return nil
 	}
 
-	return allocations.Delete(client, d.Id()).ExtractErr()
+	return diag.FromErr(allocations.Delete(client, d.Id()).ExtractErr())
 }
 
 func allocationSchemaToCreateOpts(d *schema.ResourceData) *allocations.CreateOpts {
@@ -48,6 +48,14 @@ func TestAccIronicAllocation(t *testing.T) {
 					resource.TestCheckResourceAttrPtr("ironic_node_v1."+nodeName, "instance_uuid", &allocation.UUID),
 				),
 			},
+
+			// Import the allocation by name
+			{
+				ResourceName:      "ironic_allocation_v1." + allocationName,
+				ImportState:       true,
+				ImportStateId:     allocationName,
+				ImportStateVerify: true,
+			},
 		},
 	})
 }
@@ -1,6 +1,7 @@
 package ironic
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -9,21 +10,36 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	utils "github.com/gophercloud/utils/openstack/baremetal/v1/nodes"
 	retryablehttp "github.com/hashicorp/go-retryablehttp"
-	version "github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/openshift-metal3/terraform-provider-ironic/statemachine"
 )
 
 // Schema resource definition for an Ironic deployment.
 func resourceDeployment() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceDeploymentCreate,
-		Read:   resourceDeploymentRead,
-		Delete: resourceDeploymentDelete,
+		CreateContext: resourceDeploymentCreate,
+		ReadContext:   resourceDeploymentRead,
+		UpdateContext: resourceDeploymentUpdate,
+		DeleteContext: resourceDeploymentDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceDeploymentImport,
+		},
+		CustomizeDiff: resourceDeploymentCustomizeDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultProvisionTimeout),
+			Update: schema.DefaultTimeout(defaultProvisionTimeout),
+			Delete: schema.DefaultTimeout(defaultProvisionTimeout),
+		},
+		Description: "Deploying, updating and undeploying the node waits for Ironic to provision it, for up to 24 hours unless the `timeouts` block sets otherwise",
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -39,42 +55,51 @@ func resourceDeployment() *schema.Resource {
 			"instance_info": {
 				Type:     schema.TypeMap,
 				Required: true,
-				ForceNew: true,
 			},
 			"deploy_steps": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
 			},
 			"user_data": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
 			},
 			"user_data_url": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
 			},
 			"user_data_url_ca_cert": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
 			},
 			"user_data_url_headers": {
 				Type:     schema.TypeMap,
 				Optional: true,
-				ForceNew: true,
 			},
 			"network_data": {
 				Type:     schema.TypeMap,
 				Optional: true,
-				ForceNew: true,
 			},
 			"metadata": {
 				Type:     schema.TypeMap,
 				Optional: true,
-				ForceNew: true,
+			},
+			"redeploy_on_rebuild_failure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Undeploy and deploy the node again when rebuilding it in place fails",
+			},
+			"retry": retrySchema("How failed deployment, rescue, etc. of the node are retried, overriding the provider's retry policy"),
+			"rescue": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Boot the node into the rescue ramdisk, or return it to its instance when unset",
+			},
+			"rescue_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Password of the rescue user in the rescue ramdisk",
 			},
 			"provision_state": {
 				Type:     schema.TypeString,
@@ -89,14 +114,14 @@ func resourceDeployment() *schema.Resource {
 }
 
 // Create an deployment, including driving Ironic's state machine
-func resourceDeploymentCreate(d *schema.ResourceData, meta interface{}) error {
+func resourceDeploymentCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	// Reload the resource before returning
-	defer func() { _ = resourceDeploymentRead(d, meta) }()
+	defer func() { _ = resourceDeploymentRead(ctx, d, meta) }()
 
 	nodeUUID := d.Get("node_uuid").(string)
 	// Set instance info
@@ -105,17 +130,13 @@ func resourceDeploymentCreate(d *schema.ResourceData, meta interface{}) error {
 		instanceInfoCapabilities, found := instanceInfo["capabilities"]
 		capabilities := make(map[string]string)
 		if found {
-			for _, e := range strings.Split(instanceInfoCapabilities.(string), ",") {
-				parts := strings.Split(e, ":")
-				if len(parts) != 2 {
-					return fmt.Errorf("error while parsing capabilities: %s, the correct format is key:value", e)
-				}
-				capabilities[parts[0]] = parts[1]
-
+			capabilities, err = parseCapabilities(instanceInfoCapabilities.(string))
+			if err != nil {
+				return diag.FromErr(err)
 			}
 			delete(instanceInfo, "capabilities")
 		}
-		_, err := UpdateNode(client, nodeUUID, nodes.UpdateOpts{
+		_, err := UpdateNode(ctx, client, nodeUUID, nodes.UpdateOpts{
 			nodes.UpdateOperation{
 				Op:    nodes.AddOp,
 				Path:  "/instance_info",
@@ -123,11 +144,11 @@ func resourceDeploymentCreate(d *schema.ResourceData, meta interface{}) error {
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("could not update instance info: %s", err)
+			return diag.Errorf("could not update instance info: %s", err)
 		}
 
 		if len(capabilities) != 0 {
-			_, err = UpdateNode(client, nodeUUID, nodes.UpdateOpts{
+			_, err = UpdateNode(ctx, client, nodeUUID, nodes.UpdateOpts{
 				nodes.UpdateOperation{
 					Op:    nodes.AddOp,
 					Path:  "/instance_info/capabilities",
@@ -135,23 +156,158 @@ func resourceDeploymentCreate(d *schema.ResourceData, meta interface{}) error {
 				},
 			})
 			if err != nil {
-				return fmt.Errorf("could not update instance info capabilities: %s", err)
+				return diag.Errorf("could not update instance info capabilities: %s", err)
 			}
 		}
 	}
 
 	d.SetId(nodeUUID)
 
-	// deploy_steps is a json string
-	dSteps := d.Get("deploy_steps").(string)
-	var deploySteps []nodes.DeployStep
-	if len(dSteps) > 0 {
-		deploySteps, err = buildDeploySteps(dSteps)
+	deploySteps, err := deploymentSteps(client, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	configDrive, err := deploymentConfigDrive(client, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	workflowOptions, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Deploy the node - drive Ironic state machine until node is 'active'
+	err = ChangeProvisionStateToTarget(ctx, client, nodeUUID, "active", configDrive, deploySteps, nil, workflowOptions...)
+	if err != nil {
+		return provisionDiagnostics("could not deploy", err, "")
+	}
+
+	if d.Get("rescue").(bool) {
+		if err := rescueDeployment(ctx, d, meta); err != nil {
+			return provisionDiagnostics("could not rescue", err, "rescue")
+		}
+	}
+
+	return nil
+}
+
+// Update a deployment. Changes to the instance rebuild the node in place, which doesn't clean it.
+func resourceDeploymentUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Reload the resource before returning
+	defer func() { _ = resourceDeploymentRead(ctx, d, meta) }()
+
+	rebuild := d.HasChanges("instance_info", "deploy_steps", "user_data", "user_data_url", "user_data_url_ca_cert",
+		"user_data_url_headers", "network_data", "metadata")
+	wasRescued, _ := d.GetChange("rescue")
+	rescue := d.Get("rescue").(bool)
+	// The rescue password can only be changed by rescuing the node again
+	rescueAgain := rebuild || d.HasChanges("rescue", "rescue_password")
+
+	if wasRescued.(bool) && (!rescue || rescueAgain) {
+		workflowOptions, err := resourceWorkflowOptions(d, meta)
 		if err != nil {
-			return fmt.Errorf("could not fetch deploy steps: %s", err)
+			return diag.FromErr(err)
+		}
+		err = ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetUnrescue, nil, nil, nil, workflowOptions...)
+		if err != nil {
+			return provisionDiagnostics("could not unrescue", err, "rescue")
+		}
+	}
+
+	if rebuild {
+		if err := rebuildDeployment(ctx, d, meta); err != nil {
+			return provisionDiagnostics("could not rebuild", err, "")
 		}
 	}
 
+	if rescue && rescueAgain {
+		if err := rescueDeployment(ctx, d, meta); err != nil {
+			return provisionDiagnostics("could not rescue", err, "rescue")
+		}
+	}
+
+	return nil
+}
+
+// rebuildDeployment updates the node's instance_info and redeploys it in place with a new config drive. If the
+// rebuild fails, the node is optionally undeployed and deployed again.
+func rebuildDeployment(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return err
+	}
+	// The rebuild always sends a new config drive
+	if err := requireMicroversion(client, microversionRebuildConfig, "rebuilding with a config drive"); err != nil {
+		return err
+	}
+
+	deploySteps, err := deploymentSteps(client, d)
+	if err != nil {
+		return err
+	}
+
+	configDrive, err := deploymentConfigDrive(client, d)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("instance_info") {
+		oldInfo, newInfo := d.GetChange("instance_info")
+		opts, err := instanceInfoUpdateOpts(oldInfo.(map[string]interface{}), newInfo.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+		if _, err := UpdateNode(ctx, client, d.Id(), opts); err != nil {
+			return fmt.Errorf("could not update instance info: %s", err)
+		}
+	}
+
+	workflowOptions, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return err
+	}
+	err = ChangeProvisionStateToTarget(ctx, client, d.Id(), statemachine.TargetRebuild, configDrive, deploySteps, nil, workflowOptions...)
+	if err == nil {
+		return nil
+	}
+	if !d.Get("redeploy_on_rebuild_failure").(bool) || ctx.Err() != nil {
+		return err
+	}
+
+	log.Printf("[WARN] Could not rebuild node %s, going to undeploy and deploy it again: %s", d.Id(), err)
+	if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetDeleted, nil, nil, nil, workflowOptions...); err != nil {
+		return err
+	}
+	return ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetActive, configDrive, deploySteps, nil, workflowOptions...)
+}
+
+// deploymentSteps returns the deploy steps of the deployment, which are configured as JSON
+func deploymentSteps(client *gophercloud.ServiceClient, d *schema.ResourceData) ([]nodes.DeployStep, error) {
+	dSteps := d.Get("deploy_steps").(string)
+	if len(dSteps) == 0 {
+		return nil, nil
+	}
+
+	if err := requireMicroversion(client, microversionDeploySteps, "deploy_steps"); err != nil {
+		return nil, err
+	}
+	deploySteps, err := buildDeploySteps(dSteps)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch deploy steps: %s", err)
+	}
+
+	return deploySteps, nil
+}
+
+// deploymentConfigDrive builds the config drive of the deployment from its user data, network data and metadata
+func deploymentConfigDrive(client *gophercloud.ServiceClient, d *schema.ResourceData) (interface{}, error) {
 	userData := d.Get("user_data").(string)
 	userDataURL := d.Get("user_data_url").(string)
 	userDataCaCert := d.Get("user_data_url_ca_cert").(string)
@@ -160,7 +316,7 @@ func resourceDeploymentCreate(d *schema.ResourceData, meta interface{}) error {
 	// if user_data_url is specified in addition to user_data, use the former
 	ignitionData, err := fetchFullIgnition(userDataURL, userDataCaCert, userDataHeaders)
 	if err != nil {
-		return fmt.Errorf("could not fetch data from user_data_url: %s", err)
+		return nil, fmt.Errorf("could not fetch data from user_data_url: %s", err)
 	}
 	if ignitionData != "" {
 		userData = ignitionData
@@ -170,12 +326,39 @@ func resourceDeploymentCreate(d *schema.ResourceData, meta interface{}) error {
 		userData,
 		d.Get("network_data").(map[string]interface{}),
 		d.Get("metadata").(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &configDrive, nil
+}
+
+// rescueDeployment boots the deployed node into the rescue ramdisk
+func rescueDeployment(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
+	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
 		return err
 	}
 
-	// Deploy the node - drive Ironic state machine until node is 'active'
-	return ChangeProvisionStateToTarget(client, nodeUUID, "active", &configDrive, deploySteps, nil)
+	options, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return err
+	}
+	if err := requireMicroversion(client, microversionRescue, "rescue"); err != nil {
+		return err
+	}
+
+	options = append(options, WithRescuePassword(d.Get("rescue_password").(string)))
+	return ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetRescue, nil, nil, nil, options...)
+}
+
+// resourceDeploymentCustomizeDiff refuses to plan rescuing the node without a password, which Ironic would only
+// refuse once the node is deployed
+func resourceDeploymentCustomizeDiff(_ context.Context, d *schema.ResourceDiff, _ interface{}) error {
+	if d.Get("rescue").(bool) && d.NewValueKnown("rescue_password") && d.Get("rescue_password").(string) == "" {
+		return fmt.Errorf("rescue_password is required to rescue the node")
+	}
+	return nil
 }
 
 // fetchFullIgnition gets full igntion from the URL and cert passed to it and returns userdata as a string
@@ -246,16 +429,12 @@ func buildDeploySteps(steps string) ([]nodes.DeployStep, error) {
 // buildConfigDrive handles building a config drive appropriate for the Ironic version we are using.  Newer versions
 // support sending the user data directly, otherwise we need to build an ISO image
 func buildConfigDrive(apiVersion, userData string, networkData, metaData map[string]interface{}) (interface{}, error) {
-	actual, err := version.NewVersion(apiVersion)
-	if err != nil {
-		return nil, err
-	}
-	minimum, err := version.NewVersion("1.56")
+	supported, err := versionAtLeast(apiVersion, microversionConfigDriveJSON)
 	if err != nil {
 		return nil, err
 	}
 
-	if minimum.GreaterThan(actual) {
+	if !supported {
 		// Create config drive ISO directly with gophercloud/utils
 		configDriveData := utils.ConfigDrive{
 			UserData:    utils.UserDataString(userData),
@@ -277,32 +456,123 @@ func buildConfigDrive(apiVersion, userData string, networkData, metaData map[str
 }
 
 // Read the deployment's data from Ironic
-func resourceDeploymentRead(d *schema.ResourceData, meta interface{}) error {
+func resourceDeploymentRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	// Ensure node exists first
 	id := d.Get("node_uuid").(string)
+	if id == "" {
+		id = d.Id()
+	}
 	result, err := nodes.Get(client, id).Extract()
 	if err != nil {
-		return fmt.Errorf("could not find node %s: %s", id, err)
+		return diag.Errorf("could not find node %s: %s", id, err)
 	}
 
+	err = d.Set("node_uuid", result.UUID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("instance_info", instanceInfoFromNode(result.InstanceInfo, d.Get("instance_info").(map[string]interface{})))
+	if err != nil {
+		return diag.FromErr(err)
+	}
 	err = d.Set("provision_state", result.ProvisionState)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	err = d.Set("rescue", result.ProvisionState == string(nodes.Rescue) || result.ProvisionState == string(nodes.RescueWait) ||
+		result.ProvisionState == string(nodes.Rescuing))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	return diag.FromErr(d.Set("last_error", result.LastError))
+}
+
+// parseCapabilities converts capabilities configured as "key:value,key:value" into the map Ironic expects
+func parseCapabilities(value string) (map[string]string, error) {
+	capabilities := make(map[string]string)
+	for _, e := range strings.Split(value, ",") {
+		parts := strings.Split(e, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("error while parsing capabilities: %s, the correct format is key:value", e)
+		}
+		capabilities[parts[0]] = parts[1]
 	}
-	return d.Set("last_error", result.LastError)
+
+	return capabilities, nil
+}
+
+// instanceInfoFromNode converts the node's instance_info back into the flat form used by the schema. When the
+// resource already tracks instance_info, only those keys are read back, as Ironic adds its own fields during
+// deployment. Otherwise, e.g. on import, everything except the config drive is returned.
+func instanceInfoFromNode(instanceInfo, current map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	for k, v := range instanceInfo {
+		if k == "configdrive" {
+			continue
+		}
+		if _, ok := current[k]; len(current) != 0 && !ok {
+			continue
+		}
+
+		switch value := v.(type) {
+		case string:
+			result[k] = value
+		case map[string]interface{}:
+			// Capabilities are sent as a map, but configured as "key:value,key:value"
+			if k != "capabilities" {
+				continue
+			}
+			var capabilities []string
+			for ck, cv := range value {
+				capabilities = append(capabilities, fmt.Sprintf("%s:%v", ck, cv))
+			}
+			sort.Strings(capabilities)
+			result[k] = strings.Join(capabilities, ",")
+		default:
+			result[k] = fmt.Sprintf("%v", value)
+		}
+	}
+
+	return result
+}
+
+// Import a deployment by the UUID or name of its node. The config drive contents (user_data, network_data and
+// metadata) cannot be read back from Ironic, and are left empty.
+func resourceDeploymentImport(_ context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := nodes.Get(client, d.Id()).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("could not find node %s: %s", d.Id(), err)
+	}
+	d.SetId(result.UUID)
+
+	return []*schema.ResourceData{d}, nil
 }
 
 // Delete an deployment from Ironic - this cleans the node and returns it's state to 'available'
-func resourceDeploymentDelete(d *schema.ResourceData, meta interface{}) error {
+func resourceDeploymentDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	return ChangeProvisionStateToTarget(client, d.Id(), "deleted", nil, nil, nil)
+	workflowOptions, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "deleted", nil, nil, nil, workflowOptions...); err != nil {
+		return provisionDiagnostics("could not undeploy", err, "")
+	}
+	return nil
 }
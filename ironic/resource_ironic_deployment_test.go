@@ -39,6 +39,14 @@ func TestAccIronicDeployment(t *testing.T) {
 					resource.TestCheckResourceAttr("ironic_deployment."+nodeName, "provision_state", "active"),
 				),
 			},
+
+			// Import the deployment by node UUID
+			{
+				ResourceName:            "ironic_deployment." + nodeName,
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"name", "user_data"},
+			},
 		},
 	})
 }
@@ -57,6 +65,33 @@ func TestBuildConfigDrive(t *testing.T) {
 	}
 }
 
+func TestInstanceInfoFromNode(t *testing.T) {
+	instanceInfo := map[string]interface{}{
+		"image_source": "http://example.com/image.qcow2",
+		"root_gb":      float64(25),
+		"configdrive":  "******",
+		"capabilities": map[string]interface{}{"boot_mode": "uefi", "secure_boot": "true"},
+	}
+
+	imported := instanceInfoFromNode(instanceInfo, nil)
+	expected := map[string]interface{}{
+		"image_source": "http://example.com/image.qcow2",
+		"root_gb":      "25",
+		"capabilities": "boot_mode:uefi,secure_boot:true",
+	}
+	if !reflect.DeepEqual(expected, imported) {
+		t.Errorf("expected: %v, got: %v", expected, imported)
+	}
+
+	tracked := instanceInfoFromNode(instanceInfo, map[string]interface{}{"image_source": ""})
+	expected = map[string]interface{}{
+		"image_source": "http://example.com/image.qcow2",
+	}
+	if !reflect.DeepEqual(expected, tracked) {
+		t.Errorf("expected: %v, got: %v", expected, tracked)
+	}
+}
+
 func testAccDeploymentDestroy(state *terraform.State) error {
 	client, err := testAccProvider.Meta().(*Clients).GetIronicClient()
 	if err != nil {
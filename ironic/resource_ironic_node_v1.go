@@ -1,26 +1,51 @@
 package ironic
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/ports"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	metal3v1alpha1 "github.com/metal3-io/baremetal-operator/apis/metal3.io/v1alpha1"
 	"github.com/metal3-io/baremetal-operator/pkg/provisioner/ironic"
 )
 
+// Ironic shows secrets, such as the BMC password in driver_info, as this value
+const maskedValue = "******"
+
+// The state stores a digest of those secrets instead, see secretDigest
+const secretDigestPrefix = "hmac-sha256:"
+
 // Schema resource definition for an Ironic node.
 func resourceNodeV1() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceNodeV1Create,
-		Read:   resourceNodeV1Read,
-		Update: resourceNodeV1Update,
-		Delete: resourceNodeV1Delete,
+		CreateContext: resourceNodeV1Create,
+		ReadContext:   resourceNodeV1Read,
+		UpdateContext: resourceNodeV1Update,
+		DeleteContext: resourceNodeV1Delete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceNodeV1Import,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultProvisionTimeout),
+			Update: schema.DefaultTimeout(defaultProvisionTimeout),
+			Delete: schema.DefaultTimeout(defaultProvisionTimeout),
+		},
+		Description: "Creating, updating and deleting the node waits for Ironic to provision it, for up to 24 hours unless the `timeouts` block sets otherwise",
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -52,21 +77,16 @@ func resourceNodeV1() *schema.Resource {
 				Computed: true,
 			},
 			"driver": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				AtLeastOneOf: []string{"driver", "bmc"},
 			},
+			"bmc": bmcSchema(),
 			"driver_info": {
-				Type:     schema.TypeMap,
-				Optional: true,
-				DiffSuppressFunc: func(k, old, _ string, _ *schema.ResourceData) bool {
-					/* FIXME: Password updates aren't considered. How can I know if the *local* data changed? */
-					/* FIXME: Support drivers other than IPMI */
-					if k == "driver_info.ipmi_password" && old == "******" {
-						return true
-					}
-
-					return false
-				},
+				Type:             schema.TypeMap,
+				Optional:         true,
+				DiffSuppressFunc: suppressMaskedDriverInfo,
 
 				// driver_info could contain passwords
 				Sensitive: true,
@@ -90,6 +110,12 @@ func resourceNodeV1() *schema.Resource {
 			},
 			"instance_uuid": {
 				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"instance_info": {
+				Type:     schema.TypeMap,
+				Optional: true,
 				Computed: true,
 			},
 			"inspect": {
@@ -104,6 +130,12 @@ func resourceNodeV1() *schema.Resource {
 				Type:     schema.TypeBool,
 				Optional: true,
 			},
+			"adopt": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				ConflictsWith: []string{"clean", "available"},
+				Description:   "Adopt a node that is already running, making it active without deploying it. instance_info must describe the running image, e.g. image_source",
+			},
 			"management_interface": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -155,6 +187,80 @@ func resourceNodeV1() *schema.Resource {
 					Type: schema.TypeMap,
 				},
 			},
+			"maintenance": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Computed:    true,
+				Description: "Whether the node is in maintenance mode, which stops Ironic from acting on it. Left as Ironic has it when not set",
+			},
+			"maintenance_reason": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+
+				// Ironic forgets the reason when the node leaves maintenance
+				DiffSuppressFunc: func(_, _, _ string, d *schema.ResourceData) bool {
+					return !d.Get("maintenance").(bool)
+				},
+			},
+			"fault": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The fault Ironic detected on the node, which put it in maintenance",
+			},
+			"last_error": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"target_provision_state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"provision_updated_at": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"inspection_started_at": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"inspection_finished_at": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"conductor": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The conductor currently managing the node",
+			},
+			"allocation_uuid": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"capabilities": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Description: "The capabilities from the node's properties, e.g. boot_mode",
+			},
+			"current_raid_config": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The RAID configuration of the node as reported by Ironic, in JSON",
+			},
+			"target_raid_config": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The RAID configuration Ironic will apply the next time the node is cleaned, in JSON",
+			},
+			"traits": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Computed:    true,
+				Description: "The traits of the node. Left as Ironic has them when not set, e.g. when they are managed with the CLI or inspection rules",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 			"provision_state": {
 				Type:     schema.TypeString,
 				Computed: true,
@@ -186,223 +292,471 @@ func resourceNodeV1() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 				Computed: true,
+				Description: "BIOS settings to apply when cleaning, in JSON. Only these settings are compared with the node's " +
+					"to report changes made outside of terraform, see the ironic_node_bios_settings data source for all of them",
 			},
+			"clean_steps": cleanStepsSchema(),
+			"retry":       retrySchema("How failed cleaning, inspection, etc. of the node are retried, overriding the provider's retry policy"),
 		},
 	}
 }
 
 // Create a node, including driving Ironic's state machine
-func resourceNodeV1Create(d *schema.ResourceData, meta interface{}) error {
+func resourceNodeV1Create(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	workflowOptions, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := validateAdoption(d); err != nil {
+		return diag.FromErr(err)
 	}
 
 	// Create the node object in Ironic
 	createOpts := schemaToCreateOpts(d)
+	err = applyBMCToCreateOpts(d, createOpts)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 	result, err := nodes.Create(client, createOpts).Extract()
 	if err != nil {
 		d.SetId("")
-		return err
+		return diag.FromErr(err)
 	}
 
 	// Setting the ID is what tells terraform we were successful in creating the node
 	log.Printf("[DEBUG] Node created with ID %s\n", d.Id())
 	d.SetId(result.UUID)
 
+	// If we fail or are interrupted part way through, still record the node's current state
+	defer func() {
+		if diags.HasError() {
+			_ = resourceNodeV1Read(ctx, d, meta)
+		}
+	}()
+
 	// Create ports as part of the node object - you may also use the native port resource
 	portSet := d.Get("ports").(*schema.Set)
 	if portSet != nil {
-		portList := portSet.List()
-		for _, portInterface := range portList {
-			port := portInterface.(map[string]interface{})
-
-			// Terraform map can't handle bool... seriously.
-			var pxeEnabled bool
-			if port["pxe_enabled"] != nil {
-				if port["pxe_enabled"] == "true" {
-					pxeEnabled = true
-				} else {
-					pxeEnabled = false
-				}
+		if err := createNodePorts(client, d.Id(), portSet.List()); err != nil {
+			return diag.FromErr(err)
+		}
+	}
 
-			}
-			// FIXME: All values other than address and pxe
-			portCreateOpts := ports.CreateOpts{
-				NodeUUID:   d.Id(),
-				Address:    port["address"].(string),
-				PXEEnabled: &pxeEnabled,
-			}
-			_, err := ports.Create(client, portCreateOpts).Extract()
-			if err != nil {
-				_ = resourcePortV1Read(d, meta)
-				return err
-			}
+	// Traits can't be set when creating the node
+	if traits := d.Get("traits").(*schema.Set); traits.Len() > 0 {
+		if err := setNodeTraits(client, d.Id(), sortedTraits(traits)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// The instance fields can't be set when creating the node
+	instanceOpts, err := instanceUpdateOpts(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if len(instanceOpts) > 0 {
+		if _, err := UpdateNode(ctx, client, d.Id(), instanceOpts); err != nil {
+			return diag.Errorf("could not set instance fields: %s", err)
 		}
 	}
 
 	// Make node manageable
 	if d.Get("manage").(bool) || d.Get("clean").(bool) || d.Get("inspect").(bool) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "manage", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not manage: %s", err)
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "manage", nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not manage", err, "manage")
 		}
 	}
 
 	// Clean node
 	if d.Get("clean").(bool) {
 		if err := setRAIDConfig(client, d); err != nil {
-			return fmt.Errorf("fail to set raid config: %s", err)
+			return diag.Errorf("fail to set raid config: %s", err)
 		}
 
-		var cleanSteps []nodes.CleanStep
-		if cleanSteps, err = buildManualCleaningSteps(d.Get("raid_interface").(string), d.Get("raid_config").(string), d.Get("bios_settings").(string)); err != nil {
-			return fmt.Errorf("fail to build raid clean steps: %s", err)
+		cleanSteps, err := nodeCleanSteps(d)
+		if err != nil {
+			return diag.FromErr(err)
 		}
-
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "clean", nil, nil, cleanSteps); err != nil {
-			return fmt.Errorf("could not clean: %s", err)
+		if err := cleanNode(ctx, client, d, cleanSteps, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not clean", err, "clean")
 		}
 	}
 
 	// Inspect node
 	if d.Get("inspect").(bool) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "inspect", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not inspect: %s", err)
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "inspect", nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not inspect", err, "inspect")
+		}
+	}
+
+	// Adopt a node that is already deployed
+	if d.Get("adopt").(bool) {
+		if err := requireMicroversion(client, microversionAdopt, "adopt"); err != nil {
+			return provisionDiagnostics("could not adopt", err, "adopt")
+		}
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetAdopt, nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not adopt", err, "adopt")
 		}
 	}
 
 	// Make node available
 	if d.Get("available").(bool) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "provide", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not make node available: %s", err)
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "provide", nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not make node available", err, "available")
+		}
+	}
+
+	// Maintenance is entered last, as the provisioning workflow refuses nodes in maintenance
+	if d.Get("maintenance").(bool) {
+		if err := setNodeMaintenance(client, d.Id(), d.Get("maintenance_reason").(string)); err != nil {
+			return diag.FromErr(err)
 		}
 	}
 
 	// Change power state, if required
 	if targetPowerState := d.Get("target_power_state").(string); targetPowerState != "" {
-		err := changePowerState(client, d, nodes.TargetPowerState(targetPowerState))
+		err := changePowerState(ctx, client, d, nodes.TargetPowerState(targetPowerState), meta.(*Clients).operationLimiter)
 		if err != nil {
-			return fmt.Errorf("could not change power state: %s", err)
+			return diag.Errorf("could not change power state: %s", err)
 		}
 	}
 
-	return resourceNodeV1Read(d, meta)
+	return resourceNodeV1Read(ctx, d, meta)
 }
 
 // Read the node's data from Ironic
-func resourceNodeV1Read(d *schema.ResourceData, meta interface{}) error {
+func resourceNodeV1Read(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	node, err := nodes.Get(client, d.Id()).Extract()
+	result := nodes.Get(client, d.Id())
+	node, err := result.Extract()
 	if err != nil {
 		d.SetId("")
-		return err
+		return diag.FromErr(err)
+	}
+
+	// The fields gophercloud's node doesn't have
+	var status nodeStatus
+	if err := result.ExtractInto(&status); err != nil {
+		return diag.FromErr(err)
 	}
 
 	// TODO: Ironic's Create is different than the Node object itself, GET returns things like the
 	//  RaidConfig, we need to add those and handle them in CREATE
 	err = d.Set("boot_interface", node.BootInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("conductor_group", node.ConductorGroup)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("console_interface", node.ConsoleInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("deploy_interface", node.DeployInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("driver", node.Driver)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	err = d.Set("driver_info", node.DriverInfo)
+	driverInfo, err := driverInfoWithSecretDigests(node.UUID, driverInfoWithoutBMC(d, node.DriverInfo),
+		d.Get("driver_info").(map[string]interface{}))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	err = d.Set("driver_info", driverInfo)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 	err = d.Set("extra", node.Extra)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("inspect_interface", node.InspectInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("instance_uuid", node.InstanceUUID)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	err = d.Set("instance_info", instanceInfoFromNode(node.InstanceInfo, d.Get("instance_info").(map[string]interface{})))
+	if err != nil {
+		return diag.FromErr(err)
 	}
 	err = d.Set("management_interface", node.ManagementInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("name", node.Name)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("network_interface", node.NetworkInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("owner", node.Owner)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("power_interface", node.PowerInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("power_state", node.PowerState)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("root_device", node.Properties["root_device"])
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	delete(node.Properties, "root_device")
 	err = d.Set("properties", node.Properties)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("raid_interface", node.RAIDInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("rescue_interface", node.RescueInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("resource_class", node.ResourceClass)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("storage_interface", node.StorageInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("vendor_interface", node.VendorInterface)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	err = d.Set("traits", node.Traits)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("maintenance", node.Maintenance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("maintenance_reason", node.MaintenanceReason)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("fault", node.Fault)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("last_error", node.LastError)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("target_provision_state", node.TargetProvisionState)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("provision_updated_at", status.ProvisionUpdatedAt)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("inspection_started_at", status.InspectionStartedAt)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("inspection_finished_at", status.InspectionFinishedAt)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("conductor", status.Conductor)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("allocation_uuid", status.AllocationUUID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("capabilities", capabilitiesFromProperties(node.Properties))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	currentRAIDConfig, err := raidConfigToString(node.RAIDConfig)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("current_raid_config", currentRAIDConfig)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	targetRAIDConfig, err := raidConfigToString(node.TargetRAIDConfig)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("target_raid_config", targetRAIDConfig)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Report changes to the RAID and BIOS configuration made outside of terraform
+	if configurationDriftDetectable(d, node) {
+		if node.RAIDInterface != "no-raid" {
+			raidConfig, err := raidConfigFromNode(d.Get("raid_config").(string), node.RAIDConfig)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+			err = d.Set("raid_config", raidConfig)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+		}
+
+		if node.BIOSInterface != "" && node.BIOSInterface != "no-bios" {
+			settings, err := nodes.ListBIOSSettings(client, d.Id(), nil).Extract()
+			if err != nil {
+				log.Printf("[WARN] Could not get the BIOS settings of node %s: %s", d.Id(), err)
+			} else {
+				biosSettings, err := biosSettingsFromNode(d.Get("bios_settings").(string), settings)
+				if err != nil {
+					return diag.FromErr(err)
+				}
+				err = d.Set("bios_settings", biosSettings)
+				if err != nil {
+					return diag.FromErr(err)
+				}
+			}
+		}
+	}
+	return diag.FromErr(d.Set("provision_state", node.ProvisionState))
+}
+
+// validateAdoption checks that Ironic is told what the node is running when it is adopted
+func validateAdoption(d *schema.ResourceData) error {
+	if !d.Get("adopt").(bool) {
+		return nil
+	}
+	if imageSource, _ := d.Get("instance_info").(map[string]interface{})["image_source"].(string); imageSource == "" {
+		return fmt.Errorf("instance_info must contain image_source to adopt a node")
+	}
+	return nil
+}
+
+// nodeStatus holds the fields of a node that gophercloud doesn't know about
+type nodeStatus struct {
+	ProvisionUpdatedAt   string `json:"provision_updated_at"`
+	InspectionStartedAt  string `json:"inspection_started_at"`
+	InspectionFinishedAt string `json:"inspection_finished_at"`
+	Conductor            string `json:"conductor"`
+	AllocationUUID       string `json:"allocation_uuid"`
+
+	// Only returned from API version 1.86
+	FirmwareInterface string `json:"firmware_interface"`
+}
+
+// capabilitiesFromProperties returns the capabilities of a node, which Ironic stores either as a string of
+// comma-separated key:value pairs or as a map.
+func capabilitiesFromProperties(properties map[string]interface{}) map[string]interface{} {
+	capabilities := make(map[string]interface{})
+	switch value := properties["capabilities"].(type) {
+	case string:
+		if value == "" {
+			break
+		}
+		parsed, err := parseCapabilities(value)
+		if err != nil {
+			log.Printf("[WARN] Could not parse the node's capabilities: %s", err)
+			break
+		}
+		for k, v := range parsed {
+			capabilities[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range value {
+			capabilities[k] = fmt.Sprint(v)
+		}
+	}
+	return capabilities
+}
+
+// raidConfigToString returns a RAID configuration as JSON, or an empty string when there's none
+func raidConfigToString(raidConfig map[string]interface{}) (string, error) {
+	if len(raidConfig) == 0 {
+		return "", nil
+	}
+	result, err := json.Marshal(raidConfig)
+	if err != nil {
+		return "", fmt.Errorf("could not encode RAID configuration: %s", err)
+	}
+	return string(result), nil
+}
+
+// Import a node by UUID or name. The provisioning toggles are derived from the node's current provision state, so
+// a configuration matching the node as it exists in Ironic does not trigger any state changes.
+func resourceNodeV1Import(_ context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := nodes.Get(client, d.Id()).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("could not find node %s: %s", d.Id(), err)
+	}
+	d.SetId(node.UUID)
+
+	err = d.Set("manage", node.ProvisionState == string(nodes.Manageable))
+	if err != nil {
+		return nil, err
 	}
-	return d.Set("provision_state", node.ProvisionState)
+	// A deployed node isn't available, it's managed by the deployment
+	err = d.Set("available", node.ProvisionState == string(nodes.Available))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*schema.ResourceData{d}, nil
 }
 
 // Update a node's state based on the terraform config - TODO: handle everything
-func resourceNodeV1Update(d *schema.ResourceData, meta interface{}) error {
+func resourceNodeV1Update(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	workflowOptions, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
+	if d.HasChange("adopt") {
+		if err := validateAdoption(d); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// If we fail or are interrupted part way through, still record the node's current state
+	defer func() {
+		if diags.HasError() {
+			_ = resourceNodeV1Read(ctx, d, meta)
+		}
+	}()
+
 	d.Partial(true)
 
 	stringFields := []string{
@@ -424,19 +778,100 @@ func resourceNodeV1Update(d *schema.ResourceData, meta interface{}) error {
 		"vendor_interface",
 	}
 
+	var opts nodes.UpdateOpts
 	for _, field := range stringFields {
 		if d.HasChange(field) {
-			opts := nodes.UpdateOpts{
-				nodes.UpdateOperation{
-					Op:    nodes.ReplaceOp,
-					Path:  fmt.Sprintf("/%s", field),
-					Value: d.Get(field).(string),
-				},
-			}
+			opts = append(opts, nodes.UpdateOperation{
+				Op:    nodes.ReplaceOp,
+				Path:  fmt.Sprintf("/%s", field),
+				Value: d.Get(field).(string),
+			})
+		}
+	}
 
-			if _, err := UpdateNode(client, d.Id(), opts); err != nil {
-				return err
-			}
+	// Maps are patched key by key, so only what changed is sent to Ironic
+	for _, field := range []string{"extra", "properties"} {
+		if d.HasChange(field) {
+			oldMap, newMap := d.GetChange(field)
+			opts = append(opts, mapUpdateOpts(field, oldMap.(map[string]interface{}), newMap.(map[string]interface{}), "")...)
+		}
+	}
+
+	// driver_info is what's derived from the bmc block, with the keys set explicitly taking precedence
+	if d.HasChanges("driver_info", "bmc") {
+		oldMap, newMap := d.GetChange("driver_info")
+		oldBMC, newBMC := d.GetChange("bmc")
+		// The previous bmc block was accepted already
+		oldSettings, _ := bmcSettingsFromConfig(oldBMC)
+		newSettings, err := bmcSettingsFromConfig(newBMC)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		opts = append(opts, mapUpdateOpts("driver_info", driverInfoWithBMC(oldMap.(map[string]interface{}), oldSettings),
+			driverInfoWithBMC(newMap.(map[string]interface{}), newSettings), d.Id())...)
+	}
+
+	if d.HasChange("bmc") {
+		bmcOpts, err := bmcUpdateOpts(d)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		opts = append(opts, bmcOpts...)
+	}
+
+	// root_device is stored in the node's properties
+	if d.HasChange("root_device") {
+		if rootDevice := d.Get("root_device").(map[string]interface{}); len(rootDevice) != 0 {
+			opts = append(opts, nodes.UpdateOperation{
+				Op:    nodes.AddOp,
+				Path:  "/properties/root_device",
+				Value: rootDevice,
+			})
+		} else {
+			opts = append(opts, nodes.UpdateOperation{
+				Op:   nodes.RemoveOp,
+				Path: "/properties/root_device",
+			})
+		}
+	}
+
+	instanceOpts, err := instanceUpdateOpts(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	opts = append(opts, instanceOpts...)
+
+	if len(opts) > 0 {
+		if _, err := UpdateNode(ctx, client, d.Id(), opts); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("ports") {
+		oldPorts, newPorts := d.GetChange("ports")
+		if err := updateNodePorts(client, d.Id(), oldPorts.(*schema.Set), newPorts.(*schema.Set)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("traits") {
+		oldTraits, newTraits := d.GetChange("traits")
+		if err := updateNodeTraits(client, d.Id(), oldTraits.(*schema.Set), newTraits.(*schema.Set)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// The target RAID configuration is applied the next time the node is cleaned
+	if d.HasChange("raid_config") {
+		if err := setRAIDConfig(client, d); err != nil {
+			return diag.Errorf("fail to set raid config: %s", err)
+		}
+	}
+
+	// Leave maintenance before any provisioning, and enter it only afterwards
+	if d.HasChange("maintenance") && !d.Get("maintenance").(bool) {
+		if err := unsetNodeMaintenance(client, d.Id()); err != nil {
+			return diag.FromErr(err)
 		}
 	}
 
@@ -444,70 +879,88 @@ func resourceNodeV1Update(d *schema.ResourceData, meta interface{}) error {
 	if (d.HasChange("manage") && d.Get("manage").(bool)) ||
 		(d.HasChange("clean") && d.Get("clean").(bool)) ||
 		(d.HasChange("inspect") && d.Get("inspect").(bool)) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "manage", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not manage: %s", err)
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "manage", nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not manage", err, "manage")
 		}
 	}
 
 	// Update power state if required
 	if targetPowerState := d.Get("target_power_state").(string); d.HasChange("target_power_state") && targetPowerState != "" {
-		if err := changePowerState(client, d, nodes.TargetPowerState(targetPowerState)); err != nil {
-			return err
+		if err := changePowerState(ctx, client, d, nodes.TargetPowerState(targetPowerState), meta.(*Clients).operationLimiter); err != nil {
+			return diag.FromErr(err)
 		}
 	}
 
-	// Clean node
-	if d.HasChange("clean") && d.Get("clean").(bool) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "clean", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not clean: %s", err)
+	// Clean node, again when the clean steps change. Otherwise, changes to the RAID and BIOS configuration are
+	// applied by cleaning the node with only their steps.
+	if d.Get("clean").(bool) && d.HasChanges("clean", "clean_steps") {
+		cleanSteps, err := nodeCleanSteps(d)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		if err := cleanNode(ctx, client, d, cleanSteps, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not clean", err, "clean")
+		}
+	} else if d.HasChanges("raid_config", "bios_settings") {
+		if err := reconfigureNode(ctx, client, d, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not apply the RAID and BIOS configuration", err, "")
 		}
 	}
 
 	// Inspect node
 	if d.HasChange("inspect") && d.Get("inspect").(bool) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "inspect", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not inspect: %s", err)
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "inspect", nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not inspect", err, "inspect")
+		}
+	}
+
+	// Adopt a node that is already deployed
+	if d.HasChange("adopt") && d.Get("adopt").(bool) {
+		if err := requireMicroversion(client, microversionAdopt, "adopt"); err != nil {
+			return provisionDiagnostics("could not adopt", err, "adopt")
+		}
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), nodes.TargetAdopt, nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not adopt", err, "adopt")
 		}
 	}
 
 	// Make node available
 	if d.HasChange("available") && d.Get("available").(bool) {
-		if err := ChangeProvisionStateToTarget(client, d.Id(), "provide", nil, nil, nil); err != nil {
-			return fmt.Errorf("could not make node available: %s", err)
+		if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "provide", nil, nil, nil, workflowOptions...); err != nil {
+			return provisionDiagnostics("could not make node available", err, "available")
 		}
 	}
 
-	if d.HasChange("properties") || d.HasChange("root_device") {
-		properties := propertiesMerge(d, "root_device")
-		opts := nodes.UpdateOpts{
-			nodes.UpdateOperation{
-				Op:    nodes.AddOp,
-				Path:  "/properties",
-				Value: properties,
-			},
-		}
-		if _, err := UpdateNode(client, d.Id(), opts); err != nil {
-			return err
+	if d.HasChanges("maintenance", "maintenance_reason") && d.Get("maintenance").(bool) {
+		if err := setNodeMaintenance(client, d.Id(), d.Get("maintenance_reason").(string)); err != nil {
+			return diag.FromErr(err)
 		}
 	}
 
 	d.Partial(false)
 
-	return resourceNodeV1Read(d, meta)
+	return resourceNodeV1Read(ctx, d, meta)
 }
 
 // Delete a node from Ironic
-func resourceNodeV1Delete(d *schema.ResourceData, meta interface{}) error {
+func resourceNodeV1Delete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	if err := ChangeProvisionStateToTarget(client, d.Id(), "deleted", nil, nil, nil); err != nil {
-		return err
+	workflowOptions, err := resourceWorkflowOptions(d, meta)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
-	return nodes.Delete(client, d.Id()).ExtractErr()
+	// The node is going away, so being in maintenance doesn't stop undeploying it
+	workflowOptions = append(workflowOptions, WithAllowMaintenance(true))
+	if err := ChangeProvisionStateToTarget(ctx, client, d.Id(), "deleted", nil, nil, nil, workflowOptions...); err != nil {
+		return provisionDiagnostics("could not undeploy", err, "")
+	}
+
+	return diag.FromErr(nodes.Delete(client, d.Id()).ExtractErr())
 }
 
 func propertiesMerge(d *schema.ResourceData, key string) map[string]interface{} {
@@ -543,14 +996,244 @@ func schemaToCreateOpts(d *schema.ResourceData) *nodes.CreateOpts {
 	}
 }
 
+// suppressMaskedDriverInfo hides the difference between a secret Ironic masks in driver_info, such as ipmi_password or
+// redfish_password, and its configured value, as long as that value still matches the digest stored in its place when
+// it was last sent to Ironic. Without a digest, e.g. after importing a node, the configured value is assumed to be current.
+func suppressMaskedDriverInfo(k, old, new string, d *schema.ResourceData) bool {
+	return maskedSecretUnchanged(d.Id(), old, new)
+}
+
+// maskedSecretUnchanged returns whether the configured value of a secret masked by Ironic is still the one that was sent
+// to Ironic, according to the value stored in the state
+func maskedSecretUnchanged(nodeUUID, old, new string) bool {
+	if new == "" || !isMaskedSecret(old) {
+		return false
+	}
+
+	return old == maskedValue || old == new || verifySecretDigest(nodeUUID, old, new)
+}
+
+// isMaskedSecret returns whether a driver_info value stands in for a secret, as masked by Ironic or as its digest
+func isMaskedSecret(value string) bool {
+	return value == maskedValue || strings.HasPrefix(value, secretDigestPrefix)
+}
+
+// driverInfoWithSecretDigests replaces the secrets masked by Ironic in driver_info with the digest of their configured
+// value. The digest is updated when the configured value is known, i.e. after it was sent on create or update,
+// otherwise the previous digest is kept.
+func driverInfoWithSecretDigests(nodeUUID string, driverInfo, configured map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+
+	for key, value := range driverInfo {
+		result[key] = value
+		if value != maskedValue {
+			continue
+		}
+
+		secret, _ := configured[key].(string)
+		if strings.HasPrefix(secret, secretDigestPrefix) {
+			result[key] = secret
+		} else if secret != "" && secret != maskedValue {
+			digest, err := secretDigest(nodeUUID, secret)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = digest
+		}
+	}
+
+	return result, nil
+}
+
+// secretDigest returns an HMAC of a secret keyed by the node's UUID and a random salt, which is stored in the state
+// instead of the secret itself
+func secretDigest(nodeUUID, secret string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate a salt for the secret digest: %w", err)
+	}
+
+	return secretDigestWithSalt(nodeUUID, hex.EncodeToString(salt), secret), nil
+}
+
+func secretDigestWithSalt(nodeUUID, salt, secret string) string {
+	mac := hmac.New(sha256.New, []byte(nodeUUID+salt))
+	mac.Write([]byte(secret))
+	return fmt.Sprintf("%s%s:%s", secretDigestPrefix, salt, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// verifySecretDigest returns whether a digest returned by secretDigest is the one of the secret
+func verifySecretDigest(nodeUUID, digest, secret string) bool {
+	salt, _, ok := strings.Cut(strings.TrimPrefix(digest, secretDigestPrefix), ":")
+	return ok && hmac.Equal([]byte(digest), []byte(secretDigestWithSalt(nodeUUID, salt, secret)))
+}
+
+// mapUpdateOpts builds the JSON patch that changes a map attribute of a node key by key, e.g. /driver_info/ipmi_address,
+// instead of replacing the whole map. Values masked by Ironic are never sent back, as that would overwrite the secret,
+// and neither are the configured values of masked secrets that still match their digest. nodeUUID keys the digests, it
+// is empty for maps without secrets.
+func mapUpdateOpts(field string, oldMap, newMap map[string]interface{}, nodeUUID string) nodes.UpdateOpts {
+	var opts nodes.UpdateOpts
+
+	keys := make([]string, 0, len(oldMap)+len(newMap))
+	for key := range oldMap {
+		keys = append(keys, key)
+	}
+	for key := range newMap {
+		if _, ok := oldMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := fmt.Sprintf("/%s/%s", field, escapePatchKey(key))
+		oldValue, inOld := oldMap[key]
+		newValue, inNew := newMap[key]
+
+		switch {
+		case !inNew:
+			opts = append(opts, nodes.UpdateOperation{Op: nodes.RemoveOp, Path: path})
+		case newValue == maskedValue:
+			continue
+		case nodeUUID != "" && maskedSecretUnchanged(nodeUUID, fmt.Sprint(oldValue), fmt.Sprint(newValue)):
+			continue
+		case !inOld:
+			opts = append(opts, nodes.UpdateOperation{Op: nodes.AddOp, Path: path, Value: newValue})
+		case !reflect.DeepEqual(oldValue, newValue):
+			opts = append(opts, nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: path, Value: newValue})
+		}
+	}
+
+	return opts
+}
+
+// escapePatchKey escapes a map key for use in a JSON patch path
+func escapePatchKey(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// instanceUpdateOpts builds the patch for changes to instance_info and instance_uuid. Capabilities in instance_info
+// are configured as "key:value,key:value", but stored by Ironic as a map.
+func instanceUpdateOpts(d *schema.ResourceData) (nodes.UpdateOpts, error) {
+	var opts nodes.UpdateOpts
+
+	if d.HasChange("instance_info") {
+		oldInfo, newInfo := d.GetChange("instance_info")
+		infoOpts, err := instanceInfoUpdateOpts(oldInfo.(map[string]interface{}), newInfo.(map[string]interface{}))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, infoOpts...)
+	}
+
+	if d.HasChange("instance_uuid") {
+		if instanceUUID := d.Get("instance_uuid").(string); instanceUUID != "" {
+			opts = append(opts, nodes.UpdateOperation{Op: nodes.AddOp, Path: "/instance_uuid", Value: instanceUUID})
+		} else {
+			opts = append(opts, nodes.UpdateOperation{Op: nodes.RemoveOp, Path: "/instance_uuid"})
+		}
+	}
+
+	return opts, nil
+}
+
+// instanceInfoUpdateOpts builds the patch for a change to instance_info, where capabilities are configured as
+// "key:value,key:value" but stored as a map.
+func instanceInfoUpdateOpts(oldInfo, newInfo map[string]interface{}) (nodes.UpdateOpts, error) {
+	var maps []map[string]interface{}
+	for _, info := range []map[string]interface{}{oldInfo, newInfo} {
+		converted := make(map[string]interface{})
+		for k, v := range info {
+			converted[k] = v
+		}
+		if value, ok := converted["capabilities"]; ok {
+			capabilities, err := parseCapabilities(value.(string))
+			if err != nil {
+				return nil, err
+			}
+			converted["capabilities"] = capabilities
+		}
+		maps = append(maps, converted)
+	}
+
+	return mapUpdateOpts("instance_info", maps[0], maps[1], ""), nil
+}
+
+// createNodePorts creates the ports configured inline in the node resource
+func createNodePorts(client *gophercloud.ServiceClient, nodeUUID string, portList []interface{}) error {
+	for _, portInterface := range portList {
+		port := portInterface.(map[string]interface{})
+
+		// Terraform map can't handle bool... seriously.
+		var pxeEnabled bool
+		if port["pxe_enabled"] != nil {
+			if port["pxe_enabled"] == "true" {
+				pxeEnabled = true
+			} else {
+				pxeEnabled = false
+			}
+
+		}
+		// FIXME: All values other than address and pxe
+		portCreateOpts := ports.CreateOpts{
+			NodeUUID:   nodeUUID,
+			Address:    port["address"].(string),
+			PXEEnabled: &pxeEnabled,
+		}
+		_, err := ports.Create(client, portCreateOpts).Extract()
+		if err != nil {
+			return fmt.Errorf("could not create port %s: %s", port["address"], err)
+		}
+	}
+
+	return nil
+}
+
+// updateNodePorts deletes the inline ports removed from the configuration, and creates the ones added. Ports are
+// matched by their MAC address.
+func updateNodePorts(client *gophercloud.ServiceClient, nodeUUID string, oldPorts, newPorts *schema.Set) error {
+	for _, portInterface := range oldPorts.Difference(newPorts).List() {
+		address, _ := portInterface.(map[string]interface{})["address"].(string)
+
+		page, err := ports.List(client, ports.ListOpts{Node: nodeUUID, Address: address}).AllPages()
+		if err != nil {
+			return fmt.Errorf("could not find port %s: %s", address, err)
+		}
+		existing, err := ports.ExtractPorts(page)
+		if err != nil {
+			return fmt.Errorf("could not find port %s: %s", address, err)
+		}
+
+		for _, port := range existing {
+			err := ports.Delete(client, port.UUID).ExtractErr()
+			if _, ok := err.(gophercloud.ErrDefault404); err != nil && !ok {
+				return fmt.Errorf("could not delete port %s: %s", address, err)
+			}
+		}
+	}
+
+	// Removed elements of a set of maps can show up as empty maps in the new set
+	var added []interface{}
+	for _, portInterface := range newPorts.Difference(oldPorts).List() {
+		if len(portInterface.(map[string]interface{})) != 0 {
+			added = append(added, portInterface)
+		}
+	}
+
+	return createNodePorts(client, nodeUUID, added)
+}
+
 // UpdateNode wraps gophercloud's update function, so we are able to retry on 409 when Ironic is busy.
-func UpdateNode(client *gophercloud.ServiceClient, uuid string, opts nodes.UpdateOpts) (node *nodes.Node, err error) {
+func UpdateNode(ctx context.Context, client *gophercloud.ServiceClient, uuid string, opts nodes.UpdateOpts) (node *nodes.Node, err error) {
 	interval := 5 * time.Second
 	for retries := 0; retries < 5; retries++ {
 		node, err = nodes.Update(client, uuid, opts).Extract()
 		if _, ok := err.(gophercloud.ErrDefault409); ok {
 			log.Printf("[DEBUG] Failed to update node: ironic is busy, will try again in %s", interval.String())
-			time.Sleep(interval)
+			if sleepErr := sleepWithContext(ctx, interval); sleepErr != nil {
+				return nil, sleepErr
+			}
 			interval *= 2
 		} else {
 			return
@@ -560,8 +1243,15 @@ func UpdateNode(client *gophercloud.ServiceClient, uuid string, opts nodes.Updat
 	return
 }
 
-// Call Ironic's API and change the power state of the node
-func changePowerState(client *gophercloud.ServiceClient, d *schema.ResourceData, target nodes.TargetPowerState) error {
+// Call Ironic's API and change the power state of the node. Unless power_state_timeout is set, we wait for Ironic to
+// finish until the context is done.
+func changePowerState(ctx context.Context, client *gophercloud.ServiceClient, d *schema.ResourceData, target nodes.TargetPowerState, limiter *OperationLimiter) error {
+	release, err := limiter.Acquire(ctx, d.Get("conductor_group").(string))
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	opts := nodes.PowerStateOpts{
 		Target: target,
 	}
@@ -569,8 +1259,10 @@ func changePowerState(client *gophercloud.ServiceClient, d *schema.ResourceData,
 	timeout := d.Get("power_state_timeout").(int)
 	if timeout != 0 {
 		opts.Timeout = timeout
-	} else {
-		timeout = 300 // used below for how long to wait for Ironic to finish
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
 	}
 
 	interval := 5 * time.Second
@@ -578,7 +1270,9 @@ func changePowerState(client *gophercloud.ServiceClient, d *schema.ResourceData,
 		err := nodes.ChangePowerState(client, d.Id(), opts).ExtractErr()
 		if _, ok := err.(gophercloud.ErrDefault409); ok {
 			log.Printf("[DEBUG] Failed to change power state: ironic is busy, will try again in %s", interval.String())
-			time.Sleep(interval)
+			if err := sleepWithContext(ctx, interval); err != nil {
+				return err
+			}
 			interval *= 2
 		} else {
 			break
@@ -586,7 +1280,7 @@ func changePowerState(client *gophercloud.ServiceClient, d *schema.ResourceData,
 	}
 
 	// Wait for target_power_state to be empty, i.e. Ironic thinks it's finished
-	checkInterval := 5
+	checkInterval := 5 * time.Second
 
 	for {
 		node, err := nodes.Get(client, d.Id()).Extract()
@@ -598,10 +1292,8 @@ func changePowerState(client *gophercloud.ServiceClient, d *schema.ResourceData,
 			break
 		}
 
-		time.Sleep(time.Duration(checkInterval) * time.Second)
-		timeout -= checkInterval
-		if timeout <= 0 {
-			return fmt.Errorf("timed out waiting for power state change")
+		if err := sleepWithContext(ctx, checkInterval); err != nil {
+			return fmt.Errorf("timed out waiting for power state change: %w", err)
 		}
 	}
 
@@ -100,6 +100,19 @@ func TestAccIronicNode(t *testing.T) {
 						"power_state", "power on"),
 				),
 			},
+
+			// Import the node by name
+			{
+				ResourceName:      "ironic_node_v1.node-0",
+				ImportState:       true,
+				ImportStateId:     "node-0",
+				ImportStateVerify: true,
+				ImportStateVerifyIgnore: []string{
+					"clean", "inspect", "manage", "available",
+					"target_power_state", "power_state_timeout",
+					"driver_info.ipmi_password",
+				},
+			},
 		},
 	})
 }
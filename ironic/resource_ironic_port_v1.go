@@ -1,21 +1,31 @@
 package ironic
 
 import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/ports"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func resourcePortV1() *schema.Resource {
 	return &schema.Resource{
-		Create: resourcePortV1Create,
-		Read:   resourcePortV1Read,
-		Update: resourcePortV1Update,
-		Delete: resourcePortV1Delete,
+		CreateContext: resourcePortV1Create,
+		ReadContext:   resourcePortV1Read,
+		UpdateContext: resourcePortV1Update,
+		DeleteContext: resourcePortV1Delete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourcePortV1Import,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"node_uuid": {
 				Type:     schema.TypeString,
 				Optional: true,
+				ForceNew: true,
 			},
 			"address": {
 				Type:     schema.TypeString,
@@ -49,72 +59,128 @@ func resourcePortV1() *schema.Resource {
 	}
 }
 
-func resourcePortV1Create(d *schema.ResourceData, meta interface{}) error {
+func resourcePortV1Create(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	opts := portSchemaToCreateOpts(d)
 	result, err := ports.Create(client, opts).Extract()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	d.SetId(result.UUID)
 
-	return resourcePortV1Read(d, meta)
+	return resourcePortV1Read(ctx, d, meta)
 }
 
-func resourcePortV1Read(d *schema.ResourceData, meta interface{}) error {
+func resourcePortV1Read(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client, err := meta.(*Clients).GetIronicClient()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	port, err := ports.Get(client, d.Id()).Extract()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	err = d.Set("address", port.Address)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("node_uuid", port.NodeUUID)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	err = d.Set("port_group_id", port.PortGroupUUID)
+	err = d.Set("port_group_uuid", port.PortGroupUUID)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	err = d.Set("local_link_collection", port.LocalLinkConnection)
+	err = d.Set("local_link_connection", port.LocalLinkConnection)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("pxe_enabled", port.PXEEnabled)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("physical_network", port.PhysicalNetwork)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("extra", port.Extra)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	return d.Set("is_smart_nic", port.IsSmartNIC)
+	return diag.FromErr(d.Set("is_smart_nic", port.IsSmartNIC))
 }
 
-func resourcePortV1Update(_ *schema.ResourceData, _ interface{}) error {
-	return nil
+// Import a port by UUID or by MAC address
+func resourcePortV1Import(_ context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return nil, err
+	}
 
+	if _, err := net.ParseMAC(d.Id()); err != nil {
+		port, err := ports.Get(client, d.Id()).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("could not find port %s: %s", d.Id(), err)
+		}
+		d.SetId(port.UUID)
+		return []*schema.ResourceData{d}, nil
+	}
+
+	page, err := ports.List(client, ports.ListOpts{Address: d.Id()}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	result, err := ports.ExtractPorts(page)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != 1 {
+		return nil, fmt.Errorf("expected one port with address %s, found %d", d.Id(), len(result))
+	}
+	d.SetId(result[0].UUID)
+
+	return []*schema.ResourceData{d}, nil
 }
 
-func resourcePortV1Delete(_ *schema.ResourceData, _ interface{}) error {
-	return nil
+// Update a port's attributes in Ironic, only sending the fields that changed
+func resourcePortV1Update(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	opts := portSchemaToUpdateOpts(d)
+	if len(opts) == 0 {
+		return resourcePortV1Read(ctx, d, meta)
+	}
 
+	if _, err := ports.Update(client, d.Id(), opts).Extract(); err != nil {
+		return diag.FromErr(err)
+	}
+
+	return resourcePortV1Read(ctx, d, meta)
+}
+
+// Delete a port from Ironic if it exists
+func resourcePortV1Delete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client, err := meta.(*Clients).GetIronicClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = ports.Delete(client, d.Id()).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
+	}
+
+	return diag.FromErr(err)
 }
 
 func portSchemaToCreateOpts(d *schema.ResourceData) *ports.CreateOpts {
@@ -122,15 +188,82 @@ func portSchemaToCreateOpts(d *schema.ResourceData) *ports.CreateOpts {
 	isSmartNic := d.Get("is_smart_nic").(bool)
 
 	opts := ports.CreateOpts{
-		NodeUUID:      d.Get("node_uuid").(string),
-		Address:       d.Get("address").(string),
-		PortGroupUUID: d.Get("port_group_uuid").(string),
-		//LocalLinkConnection: d.Get("local_link_collection").(map[string]interface{}),
-		PXEEnabled:      &pxeEnabled,
-		PhysicalNetwork: d.Get("physical_network").(string),
-		//Extra:               d.Get("extra").(map[string]interface{}),
-		IsSmartNIC: &isSmartNic,
+		NodeUUID:            d.Get("node_uuid").(string),
+		Address:             d.Get("address").(string),
+		PortGroupUUID:       d.Get("port_group_uuid").(string),
+		LocalLinkConnection: d.Get("local_link_connection").(map[string]interface{}),
+		PXEEnabled:          &pxeEnabled,
+		PhysicalNetwork:     d.Get("physical_network").(string),
+		Extra:               d.Get("extra").(map[string]interface{}),
+		IsSmartNIC:          &isSmartNic,
 	}
 
 	return &opts
 }
+
+// The names of the updatable port attributes in the Ironic API, which don't always match the schema
+var portAPIFields = map[string]string{
+	"address":               "address",
+	"port_group_uuid":       "portgroup_uuid",
+	"physical_network":      "physical_network",
+	"pxe_enabled":           "pxe_enabled",
+	"is_smart_nic":          "is_smartnic",
+	"local_link_connection": "local_link_connection",
+	"extra":                 "extra",
+}
+
+// Build the JSON patch for the fields that changed in the terraform config. Empty strings and maps are removed
+// from the port rather than being set to an empty value.
+func portSchemaToUpdateOpts(d *schema.ResourceData) ports.UpdateOpts {
+	var opts ports.UpdateOpts
+
+	for _, field := range []string{"address", "port_group_uuid", "physical_network"} {
+		if !d.HasChange(field) {
+			continue
+		}
+
+		if value := d.Get(field).(string); value != "" {
+			opts = append(opts, ports.UpdateOperation{
+				Op:    ports.AddOp,
+				Path:  "/" + portAPIFields[field],
+				Value: value,
+			})
+		} else {
+			opts = append(opts, ports.UpdateOperation{
+				Op:   ports.RemoveOp,
+				Path: "/" + portAPIFields[field],
+			})
+		}
+	}
+
+	for _, field := range []string{"pxe_enabled", "is_smart_nic"} {
+		if d.HasChange(field) {
+			opts = append(opts, ports.UpdateOperation{
+				Op:    ports.ReplaceOp,
+				Path:  "/" + portAPIFields[field],
+				Value: d.Get(field).(bool),
+			})
+		}
+	}
+
+	for _, field := range []string{"local_link_connection", "extra"} {
+		if !d.HasChange(field) {
+			continue
+		}
+
+		if value := d.Get(field).(map[string]interface{}); len(value) != 0 {
+			opts = append(opts, ports.UpdateOperation{
+				Op:    ports.AddOp,
+				Path:  "/" + portAPIFields[field],
+				Value: value,
+			})
+		} else {
+			opts = append(opts, ports.UpdateOperation{
+				Op:   ports.RemoveOp,
+				Path: "/" + portAPIFields[field],
+			})
+		}
+	}
+
+	return opts
+}
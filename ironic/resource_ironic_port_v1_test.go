@@ -0,0 +1,133 @@
+//go:build acceptance
+// +build acceptance
+
+package ironic
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/ports"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	th "github.com/openshift-metal3/terraform-provider-ironic/testhelper"
+)
+
+// Creates a node with a port, then updates the port in place
+func TestAccIronicPort(t *testing.T) {
+	var port ports.Port
+
+	nodeName := th.RandomString("TerraformACC-Node-", 8)
+	portName := th.RandomString("TerraformACC-Port-", 8)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccPortDestroy,
+		Steps: []resource.TestStep{
+			// Create a port
+			{
+				Config: testAccPortResource(nodeName, portName, "52:54:00:a1:b2:c3", "false", `rack = "r1"`),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckPortExists("ironic_port_v1."+portName, &port),
+					resource.TestCheckResourceAttr("ironic_port_v1."+portName, "address", "52:54:00:a1:b2:c3"),
+					resource.TestCheckResourceAttr("ironic_port_v1."+portName, "extra.rack", "r1"),
+				),
+			},
+
+			// Change the MAC, PXE flag and extra without recreating the port
+			{
+				Config: testAccPortResource(nodeName, portName, "52:54:00:d4:e5:f6", "true", `rack = "r2"`),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckPortExists("ironic_port_v1."+portName, &port),
+					resource.TestCheckResourceAttrPtr("ironic_port_v1."+portName, "id", &port.UUID),
+					resource.TestCheckResourceAttr("ironic_port_v1."+portName, "address", "52:54:00:d4:e5:f6"),
+					resource.TestCheckResourceAttr("ironic_port_v1."+portName, "pxe_enabled", "true"),
+					resource.TestCheckResourceAttr("ironic_port_v1."+portName, "extra.rack", "r2"),
+				),
+			},
+
+			// Import the port by MAC address
+			{
+				ResourceName:      "ironic_port_v1." + portName,
+				ImportState:       true,
+				ImportStateId:     "52:54:00:d4:e5:f6",
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+// Calls gophercloud directly to ensure the port exists
+func testAccCheckPortExists(name string, port *ports.Port) resource.TestCheckFunc {
+	return func(state *terraform.State) error {
+		client, err := testAccProvider.Meta().(*Clients).GetIronicClient()
+		if err != nil {
+			return err
+		}
+
+		rs, ok := state.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("not found: %s", name)
+		}
+
+		if rs.Primary.ID == "" {
+			return fmt.Errorf("no port ID is set")
+		}
+
+		result, err := ports.Get(client, rs.Primary.ID).Extract()
+		if err != nil {
+			return fmt.Errorf("port (%s) not found: %s", rs.Primary.ID, err)
+		}
+
+		*port = *result
+
+		return nil
+	}
+}
+
+// Calls gophercloud to ensure the port was destroyed
+func testAccPortDestroy(state *terraform.State) error {
+	client, err := testAccProvider.Meta().(*Clients).GetIronicClient()
+	if err != nil {
+		return err
+	}
+
+	for _, rs := range state.RootModule().Resources {
+		if rs.Type != "ironic_port_v1" {
+			continue
+		}
+
+		_, err := ports.Get(client, rs.Primary.ID).Extract()
+		if _, ok := err.(gophercloud.ErrDefault404); !ok {
+			return fmt.Errorf("unexpected error: %s, expected 404", err)
+		}
+	}
+
+	return nil
+}
+
+// Create the resource declaration for a node, and a port attached to it.
+func testAccPortResource(node, port, address, pxeEnabled, extra string) string {
+	return fmt.Sprintf(`
+		resource "ironic_node_v1" "%s" {
+			name = "%s"
+			driver = "fake-hardware"
+
+			boot_interface = "fake"
+			deploy_interface = "fake"
+			management_interface = "fake"
+			power_interface = "fake"
+			vendor_interface = "no-vendor"
+		}
+
+		resource "ironic_port_v1" "%s" {
+			node_uuid = "${ironic_node_v1.%s.id}"
+			address = "%s"
+			pxe_enabled = %s
+			extra = {
+				%s
+			}
+		}`, node, node, port, node, address, pxeEnabled, extra)
+}
@@ -0,0 +1,109 @@
+package ironic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/openshift-metal3/terraform-provider-ironic/statemachine"
+)
+
+// Schema for a retry block, setting how failed provisioning is retried. It's used by the provider, and by resources
+// to override the provider's policy.
+func retrySchema(description string) *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		MaxItems:    1,
+		Description: description,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"max_retries": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      maxRetryNumber,
+					ValidateFunc: validation.IntAtLeast(0),
+				},
+				"failure_states": {
+					Type:        schema.TypeSet,
+					Optional:    true,
+					Description: "The failure states to retry from, e.g. 'clean failed' or 'deploy failed'. All of them by default",
+					Elem: &schema.Schema{
+						Type:         schema.TypeString,
+						ValidateFunc: validateFailureState,
+					},
+				},
+				"backoff": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Description:  "How long to wait before the first retry, e.g. '1m', doubled for each retry after it up to an hour",
+					ValidateFunc: validateDuration,
+				},
+				"maintenance_on_failure": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Description: "Put the node in maintenance when giving up, so that nothing else tries to use it",
+				},
+			},
+		},
+	}
+}
+
+// retryPolicyFromConfig returns the retry policy of a retry block, or false when it isn't set
+func retryPolicyFromConfig(raw []interface{}) (RetryPolicy, bool, error) {
+	if len(raw) == 0 || raw[0] == nil {
+		return RetryPolicy{}, false, nil
+	}
+	config := raw[0].(map[string]interface{})
+
+	policy := RetryPolicy{
+		MaxRetries:           config["max_retries"].(int),
+		MaintenanceOnFailure: config["maintenance_on_failure"].(bool),
+	}
+	for _, state := range config["failure_states"].(*schema.Set).List() {
+		policy.FailureStates = append(policy.FailureStates, nodes.ProvisionState(state.(string)))
+	}
+	if backoff := config["backoff"].(string); backoff != "" {
+		duration, err := time.ParseDuration(backoff)
+		if err != nil {
+			return RetryPolicy{}, false, fmt.Errorf("invalid retry backoff: %s", err)
+		}
+		policy.Backoff = duration
+	}
+
+	return policy, true, nil
+}
+
+// resourceWorkflowOptions returns the provider's workflow options, with the resource's retry policy when it has one
+func resourceWorkflowOptions(d *schema.ResourceData, meta interface{}) ([]WorkflowOption, error) {
+	options := meta.(*Clients).workflowOptions()
+
+	policy, ok, err := retryPolicyFromConfig(d.Get("retry").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		options = append(options, WithRetryPolicy(policy))
+	}
+	return options, nil
+}
+
+// validateFailureState only accepts the "<operation> failed" states. enroll and error are failure states too, but they
+// can't be retried: enroll is where nodes start, and a node in error can only be rebuilt or deleted.
+func validateFailureState(v interface{}, k string) ([]string, []error) {
+	state := nodes.ProvisionState(v.(string))
+	if !statemachine.IsFailure(state) || !strings.HasSuffix(string(state), " failed") {
+		return nil, []error{fmt.Errorf("%s: '%s' is not a state Ironic leaves nodes in when an operation fails", k, v)}
+	}
+	return nil, nil
+}
+
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
@@ -0,0 +1,61 @@
+package ironic
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// The version of gophercloud we use doesn't support the node traits API, so these call it directly.
+
+// setNodeTraits replaces all the traits of a node
+func setNodeTraits(client *gophercloud.ServiceClient, nodeUUID string, traits []string) error {
+	if err := requireMicroversion(client, microversionTraits, "traits"); err != nil {
+		return err
+	}
+
+	_, err := client.Put(client.ServiceURL("nodes", nodeUUID, "traits"), map[string]interface{}{"traits": traits}, nil,
+		&gophercloud.RequestOpts{OkCodes: []int{204}})
+	if err != nil {
+		return fmt.Errorf("could not set traits of node %s: %s", nodeUUID, err)
+	}
+
+	return nil
+}
+
+// updateNodeTraits removes the traits removed from the configuration from the node, and adds the ones added, leaving
+// the other traits alone.
+func updateNodeTraits(client *gophercloud.ServiceClient, nodeUUID string, oldTraits, newTraits *schema.Set) error {
+	if err := requireMicroversion(client, microversionTraits, "traits"); err != nil {
+		return err
+	}
+
+	for _, trait := range sortedTraits(oldTraits.Difference(newTraits)) {
+		_, err := client.Delete(client.ServiceURL("nodes", nodeUUID, "traits", trait), nil)
+		if _, ok := err.(gophercloud.ErrDefault404); err != nil && !ok {
+			return fmt.Errorf("could not remove trait %s from node %s: %s", trait, nodeUUID, err)
+		}
+	}
+
+	for _, trait := range sortedTraits(newTraits.Difference(oldTraits)) {
+		_, err := client.Put(client.ServiceURL("nodes", nodeUUID, "traits", trait), nil, nil,
+			&gophercloud.RequestOpts{OkCodes: []int{204}})
+		if err != nil {
+			return fmt.Errorf("could not add trait %s to node %s: %s", trait, nodeUUID, err)
+		}
+	}
+
+	return nil
+}
+
+// sortedTraits returns the traits in a set, sorted so the requests are made in a predictable order
+func sortedTraits(set *schema.Set) []string {
+	traits := make([]string, 0, set.Len())
+	for _, trait := range set.List() {
+		traits = append(traits, trait.(string))
+	}
+	sort.Strings(traits)
+	return traits
+}
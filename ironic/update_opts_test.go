@@ -0,0 +1,167 @@
+package ironic
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/ports"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestMapUpdateOpts(t *testing.T) {
+	nodeUUID := "d6e8e08c-6f4c-4d6b-9f3e-5c3c1f2e8a10"
+	digest := secretDigestWithSalt(nodeUUID, "5f1c0e2a", "secret")
+
+	cases := []struct {
+		Scenario string
+		Old      map[string]interface{}
+		New      map[string]interface{}
+		NodeUUID string
+		Expected nodes.UpdateOpts
+	}{
+		{
+			Scenario: "no changes",
+			Old:      map[string]interface{}{"ipmi_address": "192.168.111.1"},
+			New:      map[string]interface{}{"ipmi_address": "192.168.111.1"},
+			Expected: nil,
+		},
+		{
+			Scenario: "add, replace and remove keys",
+			Old:      map[string]interface{}{"ipmi_address": "192.168.111.1", "ipmi_port": "623"},
+			New:      map[string]interface{}{"ipmi_address": "192.168.111.2", "ipmi_username": "admin"},
+			Expected: nodes.UpdateOpts{
+				nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver_info/ipmi_address", Value: "192.168.111.2"},
+				nodes.UpdateOperation{Op: nodes.RemoveOp, Path: "/driver_info/ipmi_port"},
+				nodes.UpdateOperation{Op: nodes.AddOp, Path: "/driver_info/ipmi_username", Value: "admin"},
+			},
+		},
+		{
+			Scenario: "masked values are not sent",
+			Old:      map[string]interface{}{"ipmi_password": "******", "ipmi_username": "admin"},
+			New:      map[string]interface{}{"ipmi_password": "******", "ipmi_username": "root"},
+			Expected: nodes.UpdateOpts{
+				nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver_info/ipmi_username", Value: "root"},
+			},
+		},
+		{
+			Scenario: "unchanged secrets are not sent",
+			Old:      map[string]interface{}{"ipmi_password": digest, "ipmi_username": "admin"},
+			New:      map[string]interface{}{"ipmi_password": "secret", "ipmi_username": "root"},
+			NodeUUID: nodeUUID,
+			Expected: nodes.UpdateOpts{
+				nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver_info/ipmi_username", Value: "root"},
+			},
+		},
+		{
+			Scenario: "changed secrets are sent",
+			Old:      map[string]interface{}{"ipmi_password": digest},
+			New:      map[string]interface{}{"ipmi_password": "rotated"},
+			NodeUUID: nodeUUID,
+			Expected: nodes.UpdateOpts{
+				nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver_info/ipmi_password", Value: "rotated"},
+			},
+		},
+		{
+			Scenario: "digests are keyed by the node",
+			Old:      map[string]interface{}{"ipmi_password": digest},
+			New:      map[string]interface{}{"ipmi_password": "secret"},
+			NodeUUID: "0b7f3d2e-9a41-4c5e-8f6a-1d2c3b4a5e69",
+			Expected: nodes.UpdateOpts{
+				nodes.UpdateOperation{Op: nodes.ReplaceOp, Path: "/driver_info/ipmi_password", Value: "secret"},
+			},
+		},
+		{
+			Scenario: "keys are escaped",
+			Old:      map[string]interface{}{},
+			New:      map[string]interface{}{"a/b~c": "value"},
+			Expected: nodes.UpdateOpts{
+				nodes.UpdateOperation{Op: nodes.AddOp, Path: "/driver_info/a~1b~0c", Value: "value"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			opts := mapUpdateOpts("driver_info", c.Old, c.New, c.NodeUUID)
+			if !reflect.DeepEqual(c.Expected, opts) {
+				t.Errorf("expected: %v, got: %v", c.Expected, opts)
+			}
+		})
+	}
+}
+
+func TestPortSchemaToUpdateOpts(t *testing.T) {
+	old := map[string]interface{}{
+		"node_uuid":             "d6e8e08c-6f4c-4d6b-9f3e-5c3c1f2e8a10",
+		"address":               "52:54:00:cf:2d:31",
+		"port_group_uuid":       "2b1e5a4e-1d33-4b1e-9d0a-7c8f9e5b6a21",
+		"physical_network":      "provisioning",
+		"pxe_enabled":           true,
+		"is_smart_nic":          false,
+		"local_link_connection": map[string]interface{}{"switch_id": "0a:1b:2c:3d:4e:5f"},
+		"extra":                 map[string]interface{}{"rack": "r1"},
+	}
+
+	cases := []struct {
+		Scenario string
+		New      map[string]interface{}
+		Expected ports.UpdateOpts
+	}{
+		{
+			Scenario: "no changes",
+			New:      old,
+			Expected: nil,
+		},
+		{
+			Scenario: "every attribute changed",
+			New: map[string]interface{}{
+				"node_uuid":             old["node_uuid"],
+				"address":               "52:54:00:cf:2d:32",
+				"port_group_uuid":       "7f0c3b9a-3f6e-4a8e-8b1d-2e4f6a8c0b32",
+				"physical_network":      "external",
+				"pxe_enabled":           false,
+				"is_smart_nic":          true,
+				"local_link_connection": map[string]interface{}{"switch_id": "0a:1b:2c:3d:4e:60"},
+				"extra":                 map[string]interface{}{"rack": "r2"},
+			},
+			Expected: ports.UpdateOpts{
+				ports.UpdateOperation{Op: ports.AddOp, Path: "/address", Value: "52:54:00:cf:2d:32"},
+				ports.UpdateOperation{Op: ports.AddOp, Path: "/portgroup_uuid", Value: "7f0c3b9a-3f6e-4a8e-8b1d-2e4f6a8c0b32"},
+				ports.UpdateOperation{Op: ports.AddOp, Path: "/physical_network", Value: "external"},
+				ports.UpdateOperation{Op: ports.ReplaceOp, Path: "/pxe_enabled", Value: false},
+				ports.UpdateOperation{Op: ports.ReplaceOp, Path: "/is_smartnic", Value: true},
+				ports.UpdateOperation{Op: ports.AddOp, Path: "/local_link_connection", Value: map[string]interface{}{"switch_id": "0a:1b:2c:3d:4e:60"}},
+				ports.UpdateOperation{Op: ports.AddOp, Path: "/extra", Value: map[string]interface{}{"rack": "r2"}},
+			},
+		},
+		{
+			Scenario: "attributes removed",
+			New: map[string]interface{}{
+				"node_uuid":    old["node_uuid"],
+				"address":      old["address"],
+				"pxe_enabled":  true,
+				"is_smart_nic": false,
+			},
+			Expected: ports.UpdateOpts{
+				ports.UpdateOperation{Op: ports.RemoveOp, Path: "/portgroup_uuid"},
+				ports.UpdateOperation{Op: ports.RemoveOp, Path: "/physical_network"},
+				ports.UpdateOperation{Op: ports.RemoveOp, Path: "/local_link_connection"},
+				ports.UpdateOperation{Op: ports.RemoveOp, Path: "/extra"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Scenario, func(t *testing.T) {
+			r := resourcePortV1()
+			d := schema.TestResourceDataRaw(t, r.Schema, old)
+			d.SetId("9c3e5f1a-8b2d-4e6f-a1c3-5d7e9f1b3a54")
+
+			opts := portSchemaToUpdateOpts(fakeUpdateData(t, r, d, nil, c.New))
+			if !reflect.DeepEqual(c.Expected, opts) {
+				t.Errorf("expected: %v, got: %v", c.Expected, opts)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package ironic
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -8,10 +9,54 @@ import (
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/openshift-metal3/terraform-provider-ironic/statemachine"
 )
 
 const maxRetryNumber = 3
 
+// maxRetryBackoff caps how long the workflow waits between retries
+const maxRetryBackoff = time.Hour
+
+// defaultProvisionTimeout is how long nodes and deployments may take to be created, updated or deleted when their
+// timeouts block doesn't say otherwise. Cleaning and deploying large servers regularly takes more than an hour, so it
+// is generous, nodes that stopped making progress are caught by the stall timeouts instead.
+const defaultProvisionTimeout = 24 * time.Hour
+
+// RetryPolicy decides how the workflow recovers when Ironic fails to move the node to the state it requested
+type RetryPolicy struct {
+	// How many times a failed request is retried before giving up
+	MaxRetries int
+
+	// The failure states to retry from, all of them when empty
+	FailureStates []nodes.ProvisionState
+
+	// How long to wait before the first retry, doubled for each retry after it up to an hour
+	Backoff time.Duration
+
+	// Whether to put the node in maintenance when giving up, so that nothing else tries to use it
+	MaintenanceOnFailure bool
+}
+
+// defaultRetryPolicy retries every failure a few times, right away
+var defaultRetryPolicy = RetryPolicy{MaxRetries: maxRetryNumber}
+
+// retries returns whether the policy retries from the failure state
+func (policy RetryPolicy) retries(state nodes.ProvisionState) bool {
+	return len(policy.FailureStates) == 0 || containsState(policy.FailureStates, state)
+}
+
+// backoff returns how long to wait before the retry, counting from 1
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+	backoff := policy.Backoff
+	for i := 1; i < retry && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
 // provisionStateWorkflow is used to track state through the process of updating's it's provision state
 type provisionStateWorkflow struct {
 	client      *gophercloud.ServiceClient
@@ -20,54 +65,206 @@ type provisionStateWorkflow struct {
 	target      nodes.TargetProvisionState
 	wait        time.Duration
 	retryNumber int
+	retryPolicy RetryPolicy
+
+	// When the workflow may retry a failed request, with the retry policy's backoff
+	retryAfter time.Time
+
+	// How often to poll the node while Ironic is working
+	pollInterval time.Duration
+
+	// Whether to abort Ironic's operation when the context is cancelled, rather than only when it times out
+	abortOnCancel bool
+
+	// Whether to change the provision state of a node in maintenance, which Ironic mostly allows
+	allowMaintenance bool
+
+	// How long the node may stay in each provision state before it's considered stalled, and whether to abort
+	// Ironic's operation then
+	stallTimeouts map[nodes.ProvisionState]time.Duration
+	abortOnStall  bool
+
+	// Fields of the node gophercloud doesn't have
+	status nodeStatus
+
+	// Limits how many workflows run at once
+	limiter *OperationLimiter
 
 	configDrive interface{}
 	deploySteps []nodes.DeployStep
 	cleanSteps  []nodes.CleanStep
 
-	operationStarted bool
+	rescuePassword string
+
+	// The last transition requested from Ironic, nil until the workflow requests one
+	transition *statemachine.Transition
+}
+
+// WorkflowOption changes the behaviour of ChangeProvisionStateToTarget
+type WorkflowOption func(*provisionStateWorkflow)
+
+// WithAbortOnCancel makes the workflow abort Ironic's operation when the context is cancelled, e.g. by the user
+// interrupting terraform. Operations are always aborted when the context's deadline is exceeded.
+func WithAbortOnCancel(abort bool) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.abortOnCancel = abort
+	}
+}
+
+// WithAllowMaintenance lets the workflow drive nodes that are in maintenance mode, which it refuses to do by default.
+func WithAllowMaintenance(allow bool) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.allowMaintenance = allow
+	}
+}
+
+// WithStallTimeouts makes the workflow give up on a node that stays in one of the provision states for longer than
+// its timeout, as measured by Ironic's provision_updated_at. When abort is set, Ironic's operation is aborted if the
+// state allows it, and a deployment stalled in wait call-back is undeployed.
+func WithStallTimeouts(timeouts map[nodes.ProvisionState]time.Duration, abort bool) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.stallTimeouts = timeouts
+		workflow.abortOnStall = abort
+	}
+}
+
+// WithRetryPolicy changes how the workflow retries failed requests.
+func WithRetryPolicy(policy RetryPolicy) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.retryPolicy = policy
+	}
+}
+
+// WithOperationLimiter makes the workflow wait for the limiter to allow an operation on the node's conductor group
+// before starting.
+func WithOperationLimiter(limiter *OperationLimiter) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.limiter = limiter
+	}
+}
+
+// WithRescuePassword sets the password of the rescue user when rescuing the node.
+func WithRescuePassword(password string) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.rescuePassword = password
+	}
+}
+
+// WithPollInterval changes how often the node is polled while waiting for Ironic. Deployments are polled less often.
+func WithPollInterval(interval time.Duration) WorkflowOption {
+	return func(workflow *provisionStateWorkflow) {
+		workflow.pollInterval = interval
+		workflow.wait = interval
+	}
 }
 
 // ChangeProvisionStateToTarget drives Ironic's state machine through the process to reach our desired end state. This requires multiple
-// possibly long-running steps.  If required, we'll build a config drive ISO for deployment.
-func ChangeProvisionStateToTarget(client *gophercloud.ServiceClient, uuid string, target nodes.TargetProvisionState, configDrive interface{}, deploySteps []nodes.DeployStep, cleanSteps []nodes.CleanStep) error {
+// possibly long-running steps.  If required, we'll build a config drive ISO for deployment. The workflow gives up when
+// the context is done, aborting Ironic's operation when the node is in a state that allows it.
+func ChangeProvisionStateToTarget(ctx context.Context, client *gophercloud.ServiceClient, uuid string, target nodes.TargetProvisionState, configDrive interface{}, deploySteps []nodes.DeployStep, cleanSteps []nodes.CleanStep, options ...WorkflowOption) error {
 	// Run the provisionStateWorkflow - this could take a while
 	wf := provisionStateWorkflow{
-		target:      target,
-		client:      client,
-		wait:        5 * time.Second,
-		uuid:        uuid,
-		configDrive: configDrive,
-		deploySteps: deploySteps,
-		cleanSteps:  cleanSteps,
-		retryNumber: maxRetryNumber,
+		target:       target,
+		client:       client,
+		wait:         5 * time.Second,
+		pollInterval: 5 * time.Second,
+		uuid:         uuid,
+		configDrive:  configDrive,
+		deploySteps:  deploySteps,
+		cleanSteps:   cleanSteps,
+		retryPolicy:  defaultRetryPolicy,
+	}
+	for _, option := range options {
+		option(&wf)
 	}
+	wf.retryNumber = wf.retryPolicy.MaxRetries
 
-	return wf.run()
+	return wf.run(ctx)
 }
 
 // Keep driving the state machine forward
-func (workflow *provisionStateWorkflow) run() error {
+func (workflow *provisionStateWorkflow) run(ctx context.Context) error {
+	if workflow.limiter != nil {
+		_ = workflow.reloadNode() // to get the conductor group, errors are handled by the first step
+		release, err := workflow.limiter.Acquire(ctx, workflow.node.ConductorGroup)
+		if err != nil {
+			return workflow.fail(fmt.Errorf("stopped waiting for other operations to finish: %w", err))
+		}
+		defer release()
+	}
+
 	log.Printf("[INFO] Beginning provisioning workflow, will try to change node to state '%s'", workflow.target)
 
 	for {
 		log.Printf("[DEBUG] Node is in state '%s'", workflow.node.ProvisionState)
 
-		done, err := workflow.next()
+		done, err := workflow.next(ctx)
+		if err != nil && ctx.Err() != nil {
+			// Interrupted while Ironic was too busy to accept a request
+			return workflow.fail(workflow.stop(ctx, ctx.Err()))
+		}
 		if err != nil {
-			_ = workflow.reloadNode() // to get the lastError
-			return fmt.Errorf("%w , last error was '%s'", err, workflow.node.LastError)
+			return workflow.fail(err)
 		}
 		if done {
 			return nil
 		}
 
-		time.Sleep(workflow.wait)
+		if err := sleepWithContext(ctx, workflow.wait); err != nil {
+			return workflow.fail(workflow.stop(ctx, err))
+		}
+	}
+}
+
+// Wrap the reason the workflow gave up in a ProvisionError, with the node as it is now
+func (workflow *provisionStateWorkflow) fail(err error) error {
+	provisionErr := &ProvisionError{
+		NodeUUID: workflow.uuid,
+		Target:   workflow.target,
+		Retries:  workflow.retryPolicy.MaxRetries - workflow.retryNumber,
+		Err:      err,
+	}
+
+	var statusErr gophercloud.StatusCodeError
+	if errors.As(err, &statusErr) {
+		provisionErr.StatusCode = statusErr.GetStatusCode()
+	}
+
+	_ = workflow.reloadNode() // to get the lastError
+	provisionErr.State = nodes.ProvisionState(workflow.node.ProvisionState)
+	provisionErr.LastError = workflow.node.LastError
+
+	var stalled *StallError
+	if errors.As(err, &stalled) {
+		// Aborting replaces the last error, keep the one from before
+		provisionErr.LastError = stalled.lastError
+	}
+
+	return provisionErr
+}
+
+// Give up on reaching the target state, aborting Ironic's current operation if possible so the node isn't left
+// waiting on a ramdisk that may never call back.
+func (workflow *provisionStateWorkflow) stop(ctx context.Context, reason error) error {
+	state := workflow.node.ProvisionState
+
+	if errors.Is(reason, context.DeadlineExceeded) || workflow.abortOnCancel {
+		if _, ok := statemachine.Find(nodes.ProvisionState(state), nodes.TargetAbort); ok {
+			log.Printf("[WARN] Stopped waiting for node %s in state '%s', aborting", workflow.uuid, state)
+			if _, err := workflow.changeProvisionState(ctx, nodes.TargetAbort); err != nil {
+				log.Printf("[WARN] Could not abort node %s: %s", workflow.uuid, err)
+			}
+		}
+	}
+
+	if errors.Is(reason, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out waiting to reach target '%s', node is '%s': %w", workflow.target, state, reason)
 	}
+	return fmt.Errorf("stopped waiting to reach target '%s', node is '%s': %w", workflow.target, state, reason)
 }
 
 // Do the next thing to get us to our target state
-func (workflow *provisionStateWorkflow) next() (bool, error) {
+func (workflow *provisionStateWorkflow) next(ctx context.Context) (bool, error) {
 	// Refresh the node on each run
 	if err := workflow.reloadNode(); err != nil {
 		return true, err
@@ -75,220 +272,157 @@ func (workflow *provisionStateWorkflow) next() (bool, error) {
 
 	log.Printf("[DEBUG] Node current state is '%s', target is %s", workflow.node.ProvisionState, workflow.target)
 
-	switch target := nodes.TargetProvisionState(workflow.target); target {
-	case nodes.TargetManage:
-		return workflow.toManageable()
-	case nodes.TargetProvide:
-		return workflow.toAvailable()
-	case nodes.TargetActive:
-		return workflow.toActive()
-	case nodes.TargetDeleted:
-		return workflow.toDeleted()
-	case nodes.TargetClean:
-		return workflow.toClean()
-	case nodes.TargetInspect:
-		return workflow.toInspect()
-	default:
-		return true, fmt.Errorf("unknown target state '%s'", target)
-	}
-}
+	state := nodes.ProvisionState(workflow.node.ProvisionState)
 
-func (workflow *provisionStateWorkflow) maybeRetry() (bool, error) {
-	state := workflow.node.ProvisionState
-	if workflow.retryNumber == 0 {
-		return true, errors.New(state)
+	if err := workflow.checkStalled(ctx, state); err != nil {
+		return true, err
 	}
 
-	workflow.retryNumber--
-	workflow.operationStarted = false
-	log.Printf("[DEBUG] Node %s is '%s', going to retry", workflow.uuid, state)
+	if time.Now().Before(workflow.retryAfter) {
+		log.Printf("[DEBUG] Node %s is '%s', waiting to retry.", workflow.uuid, state)
+		return false, nil
+	}
 
-	if state == "deploy failed" {
-		return workflow.changeProvisionState(nodes.TargetDeleted)
+	// Follow up on the last transition we requested
+	if transition := workflow.transition; transition != nil {
+		switch {
+		case containsState(transition.Via, state):
+			// Not done, no error - Ironic is working
+			log.Printf("[DEBUG] Node %s is '%s', waiting for Ironic to finish.", workflow.uuid, state)
+			return false, nil
+		case state == transition.To && transition.Target == workflow.target:
+			// We're done!
+			return true, nil
+		case containsState(transition.Failed, state):
+			return workflow.maybeRetry(ctx)
+		}
+		workflow.transition = nil
 	}
-	return workflow.changeProvisionState(nodes.TargetManage)
-}
 
-// Change a node to "manageable" stable
-func (workflow *provisionStateWorkflow) toManageable() (bool, error) {
-	switch state := workflow.node.ProvisionState; state {
-	case "manageable":
+	if statemachine.Reached(state, workflow.target) {
 		// We're done!
 		return true, nil
-	case "enroll",
-		"available":
-		return workflow.changeProvisionState(nodes.TargetManage)
-	case "adopt failed",
-		"clean failed",
-		"inspect failed":
-		return workflow.maybeRetry()
-	case "verifying":
-		// Not done, no error - Ironic is working
-		return false, nil
+	}
 
-	default:
-		return true, fmt.Errorf("cannot go from state '%s' to state 'manageable'", state)
+	// Ironic is working on an operation someone else requested, wait for it unless it can be interrupted for ours
+	if _, ok := statemachine.Find(state, workflow.target); statemachine.IsTransient(state) && !ok {
+		log.Printf("[DEBUG] Node %s is '%s', waiting for Ironic to finish.", workflow.uuid, state)
+		return false, nil
 	}
-}
 
-// Clean a node
-func (workflow *provisionStateWorkflow) toClean() (bool, error) {
-	if !workflow.operationStarted {
-		// Node must be manageable first
-		if workflow.node.ProvisionState != string(nodes.Manageable) {
-			if err := ChangeProvisionStateToTarget(workflow.client, workflow.uuid, nodes.TargetManage, nil, nil, nil); err != nil {
-				return true, err
-			}
-		}
+	return workflow.request(ctx, state)
+}
 
-		// Set target to clean
-		_, err := workflow.changeProvisionState(nodes.TargetClean)
-		if err != nil {
-			return true, err
-		}
+// Give up on the node when it has been in the same state for longer than the state's stall timeout, aborting Ironic's
+// operation when configured to and the state allows it.
+func (workflow *provisionStateWorkflow) checkStalled(ctx context.Context, state nodes.ProvisionState) error {
+	timeout, ok := workflow.stallTimeouts[state]
+	if !ok || timeout <= 0 {
+		return nil
+	}
 
-		// Marking that we should not return to manageable any more
-		workflow.operationStarted = true
-		return false, nil
+	updatedAt, err := time.Parse(time.RFC3339, workflow.status.ProvisionUpdatedAt)
+	if err != nil {
+		log.Printf("[DEBUG] Could not parse provision_updated_at of node %s: %s", workflow.uuid, err)
+		return nil
+	}
+	if time.Since(updatedAt) < timeout {
+		return nil
 	}
 
-	switch workflow.node.ProvisionState {
-	case "manageable":
-		return true, nil
-	case "cleaning",
-		"clean wait":
-		// Not done, no error - Ironic is working
-		return false, nil
-	case "clean failed":
-		return workflow.maybeRetry()
-	default:
-		return true, fmt.Errorf("could not clean node, node is currently '%s'", workflow.node.ProvisionState)
+	stalled := &StallError{
+		State:     state,
+		Since:     workflow.status.ProvisionUpdatedAt,
+		lastError: workflow.node.LastError,
+	}
+	// Ironic can't abort a deployment waiting for the ramdisk, undeploying the node stops it instead
+	abort := nodes.TargetAbort
+	if state == nodes.DeployWait {
+		abort = nodes.TargetDeleted
+	}
+	if _, ok := statemachine.Find(state, abort); ok && workflow.abortOnStall {
+		log.Printf("[WARN] Node %s is stalled in state '%s', requesting '%s'", workflow.uuid, state, abort)
+		if _, err := workflow.changeProvisionState(ctx, abort); err != nil {
+			log.Printf("[WARN] Could not abort node %s: %s", workflow.uuid, err)
+		} else {
+			stalled.Aborted = true
+		}
 	}
+	return stalled
 }
 
-// Inspect a node
-func (workflow *provisionStateWorkflow) toInspect() (bool, error) {
-	if !workflow.operationStarted {
-		// Node must be manageable first
-		if workflow.node.ProvisionState != string(nodes.Manageable) {
-			if err := ChangeProvisionStateToTarget(workflow.client, workflow.uuid, nodes.TargetManage, nil, nil, nil); err != nil {
-				return true, err
-			}
-		}
+// Request the first transition on the path from the state to the target
+func (workflow *provisionStateWorkflow) request(ctx context.Context, state nodes.ProvisionState) (bool, error) {
+	// A node in maintenance that already reached the target is fine, only changing it is refused
+	if workflow.node.Maintenance && !workflow.allowMaintenance {
+		return true, fmt.Errorf("%w (reason: '%s'), refusing to change it to target '%s'",
+			ErrNodeInMaintenance, workflow.node.MaintenanceReason, workflow.target)
+	}
+
+	var transition statemachine.Transition
 
-		// Set target to inspect
-		_, err := workflow.changeProvisionState(nodes.TargetInspect)
+	// A failed deployment is undeployed, which cleans the node, before deploying it again. Rebuilds are retried in
+	// place as they shouldn't lose the node's data. Ironic only rebuilds or undeploys a node in error, so it's
+	// undeployed before deploying it too.
+	if (state == nodes.DeployFail && workflow.target != statemachine.TargetRebuild) ||
+		(state == nodes.Error && workflow.target == nodes.TargetActive) {
+		transition, _ = statemachine.Find(state, nodes.TargetDeleted)
+	} else {
+		path, err := statemachine.Path(state, workflow.target)
 		if err != nil {
 			return true, err
 		}
-
-		// Marking that we should not return to manageable any more
-		workflow.operationStarted = true
-		return false, nil
+		if len(path) == 0 {
+			return true, nil
+		}
+		transition = path[0]
 	}
 
-	switch workflow.node.ProvisionState {
-	case "manageable":
-		return true, nil
-	case "inspecting",
-		"inspect wait":
-		// Not done, no error - Ironic is working
-		return false, nil
-	case "inspect failed":
-		return workflow.maybeRetry()
-	default:
-		return true, fmt.Errorf("could not inspect node, node is currently '%s'", workflow.node.ProvisionState)
+	log.Printf("[DEBUG] Node %s is '%s', going to request '%s'.", workflow.uuid, state, transition.Target)
+	if containsState(transition.Via, nodes.Deploying) {
+		workflow.wait = 6 * workflow.pollInterval // Deployment takes a while
 	}
+	workflow.transition = &transition
+	return workflow.changeProvisionState(ctx, transition.Target)
 }
 
-// Change a node to "available" state
-func (workflow *provisionStateWorkflow) toAvailable() (bool, error) {
-	switch state := workflow.node.ProvisionState; state {
-	case "available":
-		// We're done!
-		return true, nil
-	case "cleaning",
-		"clean wait":
-		// Not done, no error - Ironic is working
-		log.Printf("[DEBUG] Node %s is '%s', waiting for Ironic to finish.", workflow.uuid, state)
-		return false, nil
-	case "manageable":
-		// From manageable, we can go to provide
-		log.Printf("[DEBUG] Node %s is '%s', going to change to 'available'", workflow.uuid, state)
-		return workflow.changeProvisionState(nodes.TargetProvide)
-	case "deploy failed":
-		return workflow.maybeRetry()
-	default:
-		// Otherwise we have to get into manageable state first
-		log.Printf("[DEBUG] Node %s is '%s', going to change to 'manageable'.", workflow.uuid, state)
-		_, err := workflow.toManageable()
-		if err != nil {
-			return true, err
+// A transition failed, request it again if the retry policy allows it
+func (workflow *provisionStateWorkflow) maybeRetry(ctx context.Context) (bool, error) {
+	state := nodes.ProvisionState(workflow.node.ProvisionState)
+	policy := workflow.retryPolicy
+	if workflow.retryNumber == 0 || !policy.retries(state) {
+		if policy.MaintenanceOnFailure {
+			reason := fmt.Sprintf("gave up on reaching target '%s' after %d retries, node is '%s'", workflow.target,
+				policy.MaxRetries-workflow.retryNumber, state)
+			if err := setNodeMaintenance(workflow.client, workflow.uuid, reason); err != nil {
+				log.Printf("[WARN] %s", err)
+			}
 		}
-		return false, nil
+		return true, fmt.Errorf("%w in state '%s' after %d retries", ErrProvisionFailed, state,
+			policy.MaxRetries-workflow.retryNumber)
 	}
-}
 
-// Change a node to "active" state
-func (workflow *provisionStateWorkflow) toActive() (bool, error) {
-	switch state := workflow.node.ProvisionState; state {
-	case "active":
-		// We're done!
-		log.Printf("[DEBUG] Node %s is 'active', we are done.", workflow.uuid)
-		return true, nil
-	case "deploying",
-		"wait call-back":
-		// Not done, no error - Ironic is working
-		log.Printf("[DEBUG] Node %s is '%s', waiting for Ironic to finish.", workflow.uuid, state)
-		return false, nil
-	case "available":
-		// From available, we can go to active
-		log.Printf("[DEBUG] Node %s is 'available', going to change to 'active'.", workflow.uuid)
-		workflow.wait = 30 * time.Second // Deployment takes a while
-		return workflow.changeProvisionState(nodes.TargetActive)
-	default:
-		// Otherwise we have to get into available state first
-		log.Printf("[DEBUG] Node %s is '%s', going to change to 'available'.", workflow.uuid, state)
-		_, err := workflow.toAvailable()
-		if err != nil {
-			return true, err
-		}
+	workflow.retryNumber--
+	workflow.transition = nil
+
+	if policy.Backoff > 0 {
+		backoff := policy.backoff(policy.MaxRetries - workflow.retryNumber)
+		log.Printf("[DEBUG] Node %s is '%s', going to retry in %s", workflow.uuid, state, backoff)
+		workflow.retryAfter = time.Now().Add(backoff)
 		return false, nil
 	}
+
+	log.Printf("[DEBUG] Node %s is '%s', going to retry", workflow.uuid, state)
+	return workflow.request(ctx, state)
 }
 
-// Change a node to be "deleted," and remove the object from Ironic
-func (workflow *provisionStateWorkflow) toDeleted() (bool, error) {
-	switch state := workflow.node.ProvisionState; state {
-	case "manageable",
-		"available",
-		"enroll":
-		// We're done deleting the node
-		return true, nil
-	case "cleaning",
-		"deleting":
-		// Not done, no error - Ironic is working
-		log.Printf("[DEBUG] Node %s is '%s', waiting for Ironic to finish.", workflow.uuid, state)
-		return false, nil
-	case "active",
-		"wait call-back",
-		"error":
-		log.Printf("[DEBUG] Node %s is '%s', going to change to 'deleted'.", workflow.uuid, state)
-		return workflow.changeProvisionState(nodes.TargetDeleted)
-	case "deploy failed":
-		return workflow.maybeRetry()
-	case "inspect failed",
-		"clean failed":
-		// We have to get into manageable state first
-		log.Printf("[DEBUG] Node %s is '%s', going to change to 'manageable'.", workflow.uuid, state)
-		_, err := workflow.toManageable()
-		if err != nil {
-			return true, err
+func containsState(states []nodes.ProvisionState, state nodes.ProvisionState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
 		}
-		return false, nil
-	default:
-		return true, fmt.Errorf("cannot delete node in state '%s'", state)
 	}
+	return false
 }
 
 // Builds the ProvisionStateOpts to send to Ironic -- including config drive.
@@ -298,13 +432,16 @@ func (workflow *provisionStateWorkflow) buildProvisionStateOpts(target nodes.Tar
 	}
 
 	// If we're deploying, then build a config drive to send to Ironic
-	if target == "active" {
+	if target == nodes.TargetActive || target == statemachine.TargetRebuild {
 		opts.ConfigDrive = workflow.configDrive
 
 		if workflow.deploySteps != nil {
 			opts.DeploySteps = workflow.deploySteps
 		}
 	}
+	if target == nodes.TargetRescue {
+		opts.RescuePassword = workflow.rescuePassword
+	}
 	if target == "clean" {
 		if workflow.cleanSteps != nil {
 			opts.CleanSteps = workflow.cleanSteps
@@ -317,7 +454,7 @@ func (workflow *provisionStateWorkflow) buildProvisionStateOpts(target nodes.Tar
 }
 
 // Call Ironic's API and issue the change provision state request.
-func (workflow *provisionStateWorkflow) changeProvisionState(target nodes.TargetProvisionState) (bool, error) {
+func (workflow *provisionStateWorkflow) changeProvisionState(ctx context.Context, target nodes.TargetProvisionState) (bool, error) {
 	opts, err := workflow.buildProvisionStateOpts(target)
 	if err != nil {
 		log.Printf("[ERROR] Unable to construct provisioning state options: %s", err.Error())
@@ -328,12 +465,14 @@ func (workflow *provisionStateWorkflow) changeProvisionState(target nodes.Target
 		return true, nil
 	}
 
-	interval := 5 * time.Second
+	interval := workflow.pollInterval
 	for retries := 0; retries < 5; retries++ {
 		err = nodes.ChangeProvisionState(workflow.client, workflow.uuid, *opts).ExtractErr()
 		if _, ok := err.(gophercloud.ErrDefault409); ok {
 			log.Printf("[DEBUG] Failed to change provision state: ironic is busy, will retry in %s.", interval.String())
-			time.Sleep(interval)
+			if err := sleepWithContext(ctx, interval); err != nil {
+				return true, err
+			}
 			interval *= 2
 		} else {
 			break
@@ -345,5 +484,19 @@ func (workflow *provisionStateWorkflow) changeProvisionState(target nodes.Target
 
 // Call Ironic's API and reload the node's current state
 func (workflow *provisionStateWorkflow) reloadNode() error {
-	return nodes.Get(workflow.client, workflow.uuid).ExtractInto(&workflow.node)
+	result := nodes.Get(workflow.client, workflow.uuid)
+	if err := result.ExtractInto(&workflow.node); err != nil {
+		return err
+	}
+	return result.ExtractInto(&workflow.status)
+}
+
+// Sleep for the given duration, returning early with the context's error if it is done first.
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
 }
@@ -0,0 +1,689 @@
+package ironic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/openshift-metal3/terraform-provider-ironic/statemachine"
+	th "github.com/openshift-metal3/terraform-provider-ironic/testhelper"
+)
+
+func TestWorkflow(t *testing.T) {
+	testCases := []struct {
+		Scenario      string
+		State         string
+		Target        nodes.TargetProvisionState
+		Failures      int
+		Busy          int
+		ExpectedState string
+		ExpectedCalls []string
+		ExpectedError string
+	}{
+		{
+			Scenario:      "enroll to available",
+			State:         "enroll",
+			Target:        nodes.TargetProvide,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"manage", "provide"},
+		},
+		{
+			Scenario:      "retry failed cleaning",
+			State:         "manageable",
+			Target:        nodes.TargetProvide,
+			Failures:      1,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"provide", "manage", "provide"},
+		},
+		{
+			Scenario:      "give up when retries are exhausted",
+			State:         "manageable",
+			Target:        nodes.TargetProvide,
+			Failures:      maxRetryNumber + 1,
+			ExpectedState: "clean failed",
+			ExpectedCalls: []string{"provide", "manage", "provide", "manage", "provide", "manage", "provide"},
+			ExpectedError: "last error was 'cleaning failed'",
+		},
+		{
+			Scenario:      "retry when ironic is busy",
+			State:         "manageable",
+			Target:        nodes.TargetProvide,
+			Busy:          2,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"provide"},
+		},
+		{
+			Scenario:      "deploy",
+			State:         "available",
+			Target:        nodes.TargetActive,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"active"},
+		},
+		{
+			Scenario:      "undeploy",
+			State:         "active",
+			Target:        nodes.TargetDeleted,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"deleted"},
+		},
+		{
+			Scenario:      "inspect",
+			State:         "enroll",
+			Target:        nodes.TargetInspect,
+			ExpectedState: "manageable",
+			ExpectedCalls: []string{"manage", "inspect"},
+		},
+		{
+			Scenario:      "adopt",
+			State:         "enroll",
+			Target:        nodes.TargetAdopt,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"manage", "adopt"},
+		},
+		{
+			Scenario:      "adopt an available node",
+			State:         "available",
+			Target:        nodes.TargetAdopt,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"manage", "adopt"},
+		},
+		{
+			Scenario:      "rebuild",
+			State:         "active",
+			Target:        statemachine.TargetRebuild,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"rebuild"},
+		},
+		{
+			Scenario:      "rebuild an available node",
+			State:         "available",
+			Target:        statemachine.TargetRebuild,
+			ExpectedState: "available",
+			ExpectedError: "cannot reach target 'rebuild' from state 'available'",
+		},
+		{
+			Scenario:      "rescue without a password",
+			State:         "active",
+			Target:        nodes.TargetRescue,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"rescue"},
+			ExpectedError: "non-empty 'rescue_password' is required",
+		},
+		{
+			Scenario:      "unrescue",
+			State:         "rescue",
+			Target:        nodes.TargetUnrescue,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"unrescue"},
+		},
+		{
+			Scenario:      "undeploy a rescued node",
+			State:         "rescue",
+			Target:        nodes.TargetDeleted,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"deleted"},
+		},
+		{
+			Scenario:      "manage an active node",
+			State:         "active",
+			Target:        nodes.TargetManage,
+			ExpectedState: "active",
+			ExpectedError: "cannot reach target 'manage' from state 'active'",
+		},
+		{
+			Scenario:      "undeploy a node waiting for the ramdisk",
+			State:         "wait call-back",
+			Target:        nodes.TargetDeleted,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"deleted"},
+		},
+		{
+			Scenario:      "undeploy a node that failed cleaning",
+			State:         "clean failed",
+			Target:        nodes.TargetDeleted,
+			ExpectedState: "manageable",
+			ExpectedCalls: []string{"manage"},
+		},
+		{
+			Scenario:      "undeploy a node that failed deploying before deploying it again",
+			State:         "deploy failed",
+			Target:        nodes.TargetActive,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"deleted", "active"},
+		},
+		{
+			Scenario:      "undeploy a node in error before deploying it again",
+			State:         "error",
+			Target:        nodes.TargetActive,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"deleted", "active"},
+		},
+		{
+			Scenario:      "deploy an enrolled node",
+			State:         "enroll",
+			Target:        nodes.TargetActive,
+			ExpectedState: "active",
+			ExpectedCalls: []string{"manage", "provide", "active"},
+		},
+		{
+			Scenario:      "clean an available node without clean steps",
+			State:         "available",
+			Target:        nodes.TargetClean,
+			ExpectedState: "manageable",
+			ExpectedCalls: []string{"manage"},
+		},
+		{
+			Scenario:      "clean a node on hold",
+			State:         "clean hold",
+			Target:        nodes.TargetClean,
+			ExpectedState: "clean hold",
+			ExpectedError: "cannot reach target 'clean' from state 'clean hold'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+
+			uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": tc.State})
+			for i := 0; i < tc.Failures; i++ {
+				fake.FailProvision(uuid, "provide", "cleaning failed")
+			}
+			fake.SetBusy(uuid, tc.Busy)
+
+			err := ChangeProvisionStateToTarget(context.Background(), fakeServiceClient(fake), uuid, tc.Target, nil, nil, nil,
+				WithPollInterval(time.Millisecond))
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+			} else {
+				th.AssertNoError(t, err)
+			}
+
+			if state := fake.Node(uuid)["provision_state"]; state != tc.ExpectedState {
+				t.Errorf("expected node to be '%s', but it is '%s'", tc.ExpectedState, state)
+			}
+			if calls := fake.ProvisionTargets(uuid); fmt.Sprint(calls) != fmt.Sprint(tc.ExpectedCalls) {
+				t.Errorf("expected the workflow to request %v, but it requested %v", tc.ExpectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestWorkflowAbortsOnTimeout(t *testing.T) {
+	fake := th.NewFakeIronic()
+	defer fake.Close()
+	uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "clean wait"})
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+
+	err := ChangeProvisionStateToTarget(ctx, fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil)
+	th.AssertError(t, err, "timed out waiting")
+
+	if targets := fake.ProvisionTargets(uuid); fmt.Sprint(targets) != "[abort]" {
+		t.Errorf("expected the workflow to abort the node, but it requested %v", targets)
+	}
+	if state := fake.Node(uuid)["provision_state"]; state != "clean failed" {
+		t.Errorf("expected the node to be 'clean failed' after aborting, but it is '%s'", state)
+	}
+}
+
+func TestWorkflowCancel(t *testing.T) {
+	testCases := []struct {
+		Scenario      string
+		AbortOnCancel bool
+		Expected      []string
+	}{
+		{
+			Scenario: "leave the operation running",
+			Expected: nil,
+		},
+		{
+			Scenario:      "abort the operation",
+			AbortOnCancel: true,
+			Expected:      []string{string(nodes.TargetAbort)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+			uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "clean wait"})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := ChangeProvisionStateToTarget(ctx, fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil,
+				WithAbortOnCancel(tc.AbortOnCancel))
+			th.AssertError(t, err, "stopped waiting")
+
+			if targets := fake.ProvisionTargets(uuid); fmt.Sprint(targets) != fmt.Sprint(tc.Expected) {
+				t.Errorf("expected the workflow to request %v, but it requested %v", tc.Expected, targets)
+			}
+		})
+	}
+}
+
+func TestWorkflowCancelWhileBusy(t *testing.T) {
+	fake := th.NewFakeIronic()
+	defer fake.Close()
+	uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "manageable"})
+	fake.SetBusy(uuid, 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Retrying the busy requests would take hours if they ignored the context
+	start := time.Now()
+	err := ChangeProvisionStateToTarget(ctx, fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil,
+		WithPollInterval(time.Hour))
+	th.AssertError(t, err, "timed out waiting")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the workflow to stop when the context is done, it took %s", elapsed)
+	}
+}
+
+func TestWorkflowStalled(t *testing.T) {
+	testCases := []struct {
+		Scenario      string
+		State         string
+		UpdatedAt     string
+		AbortOnStall  bool
+		ExpectedState string
+		ExpectedCalls []string
+		ExpectedError string
+	}{
+		{
+			Scenario:      "give up on a stalled node",
+			State:         "clean wait",
+			UpdatedAt:     "2020-01-01T00:00:00Z",
+			ExpectedState: "clean wait",
+			ExpectedError: "stalled in state 'clean wait' since 2020-01-01T00:00:00Z, last error was 'ramdisk unreachable'",
+		},
+		{
+			Scenario:      "abort a stalled node",
+			State:         "clean wait",
+			UpdatedAt:     "2020-01-01T00:00:00Z",
+			AbortOnStall:  true,
+			ExpectedState: "clean failed",
+			ExpectedCalls: []string{"abort"},
+			ExpectedError: "stalled in state 'clean wait' since 2020-01-01T00:00:00Z, aborted it, last error was 'ramdisk unreachable'",
+		},
+		{
+			Scenario:      "undeploy a stalled deployment",
+			State:         "wait call-back",
+			UpdatedAt:     "2020-01-01T00:00:00Z",
+			AbortOnStall:  true,
+			ExpectedState: "cleaning",
+			ExpectedCalls: []string{"deleted"},
+			ExpectedError: "stalled in state 'wait call-back' since 2020-01-01T00:00:00Z, aborted it",
+		},
+		{
+			Scenario:      "give up on a stalled node that can't be aborted",
+			State:         "deploying",
+			UpdatedAt:     "2020-01-01T00:00:00Z",
+			AbortOnStall:  true,
+			ExpectedState: "deploying",
+			ExpectedError: "stalled in state 'deploying'",
+		},
+		{
+			Scenario:      "ignore states without a stall timeout",
+			State:         "clean failed",
+			UpdatedAt:     "2020-01-01T00:00:00Z",
+			AbortOnStall:  true,
+			ExpectedState: "available",
+			ExpectedCalls: []string{"manage", "provide"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+			uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": tc.State,
+				"provision_updated_at": tc.UpdatedAt, "last_error": "ramdisk unreachable"})
+
+			timeouts := map[nodes.ProvisionState]time.Duration{nodes.CleanWait: time.Hour, nodes.DeployWait: time.Hour,
+				nodes.Deploying: time.Hour}
+			err := ChangeProvisionStateToTarget(context.Background(), fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil,
+				WithPollInterval(time.Millisecond), WithStallTimeouts(timeouts, tc.AbortOnStall))
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+			} else {
+				th.AssertNoError(t, err)
+			}
+
+			if state := fake.Node(uuid)["provision_state"]; state != tc.ExpectedState {
+				t.Errorf("expected node to be '%s', but it is '%s'", tc.ExpectedState, state)
+			}
+			if calls := fake.ProvisionTargets(uuid); fmt.Sprint(calls) != fmt.Sprint(tc.ExpectedCalls) {
+				t.Errorf("expected the workflow to request %v, but it requested %v", tc.ExpectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestWorkflowRetryPolicy(t *testing.T) {
+	testCases := []struct {
+		Scenario            string
+		Policy              RetryPolicy
+		Failures            int
+		ExpectedState       string
+		ExpectedCalls       []string
+		ExpectedError       string
+		ExpectedMaintenance bool
+		ExpectedDuration    time.Duration
+	}{
+		{
+			Scenario:      "give up after the maximum retries",
+			Policy:        RetryPolicy{MaxRetries: 1},
+			Failures:      2,
+			ExpectedState: "clean failed",
+			ExpectedCalls: []string{"provide", "manage", "provide"},
+			ExpectedError: "clean failed",
+		},
+		{
+			Scenario:      "only retry from the listed failure states",
+			Policy:        RetryPolicy{MaxRetries: 3, FailureStates: []nodes.ProvisionState{nodes.DeployFail}},
+			Failures:      1,
+			ExpectedState: "clean failed",
+			ExpectedCalls: []string{"provide"},
+			ExpectedError: "clean failed",
+		},
+		{
+			Scenario:            "put the node in maintenance when giving up",
+			Policy:              RetryPolicy{MaintenanceOnFailure: true},
+			Failures:            1,
+			ExpectedState:       "clean failed",
+			ExpectedCalls:       []string{"provide"},
+			ExpectedError:       "clean failed",
+			ExpectedMaintenance: true,
+		},
+		{
+			Scenario:         "wait between retries",
+			Policy:           RetryPolicy{MaxRetries: 2, Backoff: 10 * time.Millisecond},
+			Failures:         2,
+			ExpectedState:    "available",
+			ExpectedCalls:    []string{"provide", "manage", "provide", "manage", "provide"},
+			ExpectedDuration: 30 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+
+			uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "manageable"})
+			for i := 0; i < tc.Failures; i++ {
+				fake.FailProvision(uuid, "provide", "cleaning failed")
+			}
+
+			start := time.Now()
+			err := ChangeProvisionStateToTarget(context.Background(), fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil,
+				WithPollInterval(time.Millisecond), WithRetryPolicy(tc.Policy))
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+			} else {
+				th.AssertNoError(t, err)
+			}
+			if elapsed := time.Since(start); elapsed < tc.ExpectedDuration {
+				t.Errorf("expected the retries to take at least %s, they took %s", tc.ExpectedDuration, elapsed)
+			}
+
+			node := fake.Node(uuid)
+			if node["provision_state"] != tc.ExpectedState {
+				t.Errorf("expected node to be '%s', but it is '%s'", tc.ExpectedState, node["provision_state"])
+			}
+			if calls := fake.ProvisionTargets(uuid); fmt.Sprint(calls) != fmt.Sprint(tc.ExpectedCalls) {
+				t.Errorf("expected the workflow to request %v, but it requested %v", tc.ExpectedCalls, calls)
+			}
+			if node["maintenance"] != tc.ExpectedMaintenance {
+				t.Errorf("expected maintenance to be %v, got %v (reason: %v)", tc.ExpectedMaintenance, node["maintenance"], node["maintenance_reason"])
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 1000, Backoff: time.Minute}
+	for retry, expected := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 7: time.Hour, 1000: time.Hour} {
+		if backoff := policy.backoff(retry); backoff != expected {
+			t.Errorf("expected retry %d to wait %s, got %s", retry, expected, backoff)
+		}
+	}
+}
+
+func TestValidateFailureState(t *testing.T) {
+	for state, valid := range map[string]bool{
+		"clean failed":  true,
+		"deploy failed": true,
+		"adopt failed":  true,
+		"enroll":        false,
+		"error":         false,
+		"active":        false,
+	} {
+		if _, errs := validateFailureState(state, "retry.0.states.0"); (len(errs) == 0) != valid {
+			t.Errorf("expected '%s' to be valid: %v, got %v", state, valid, errs)
+		}
+	}
+}
+
+func TestWorkflowMaintenance(t *testing.T) {
+	testCases := []struct {
+		Scenario         string
+		AllowMaintenance bool
+		ExpectedState    string
+		ExpectedError    string
+	}{
+		{
+			Scenario:      "refuse a node in maintenance",
+			ExpectedState: "manageable",
+			ExpectedError: "in maintenance (reason: 'broken fan')",
+		},
+		{
+			Scenario:         "allow a node in maintenance",
+			AllowMaintenance: true,
+			ExpectedState:    "available",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+			uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "manageable",
+				"maintenance": true, "maintenance_reason": "broken fan"})
+
+			err := ChangeProvisionStateToTarget(context.Background(), fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil,
+				WithPollInterval(time.Millisecond), WithAllowMaintenance(tc.AllowMaintenance))
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+			} else {
+				th.AssertNoError(t, err)
+			}
+
+			if state := fake.Node(uuid)["provision_state"]; state != tc.ExpectedState {
+				t.Errorf("expected node to be '%s', but it is '%s'", tc.ExpectedState, state)
+			}
+		})
+	}
+}
+
+func TestWorkflowErrors(t *testing.T) {
+	testCases := []struct {
+		Scenario           string
+		Node               map[string]interface{}
+		Failures           int
+		Options            []WorkflowOption
+		ExpectedCause      error
+		ExpectedState      nodes.ProvisionState
+		ExpectedLastError  string
+		ExpectedRetries    int
+		ExpectedStatusCode int
+		ExpectedDetail     string
+	}{
+		{
+			Scenario:          "provisioning failed",
+			Node:              map[string]interface{}{"provision_state": "manageable"},
+			Failures:          maxRetryNumber + 1,
+			ExpectedCause:     ErrProvisionFailed,
+			ExpectedState:     "clean failed",
+			ExpectedLastError: "cleaning failed",
+			ExpectedRetries:   maxRetryNumber,
+			ExpectedDetail:    "Provision state: clean failed\nTarget: provide\nRetries: 3\nLast error: cleaning failed",
+		},
+		{
+			Scenario:       "node in maintenance",
+			Node:           map[string]interface{}{"provision_state": "manageable", "maintenance": true},
+			ExpectedCause:  ErrNodeInMaintenance,
+			ExpectedState:  "manageable",
+			ExpectedDetail: "Provision state: manageable\nTarget: provide\nRetries: 0",
+		},
+		{
+			Scenario: "stalled node",
+			Node: map[string]interface{}{"provision_state": "clean wait", "provision_updated_at": "2020-01-01T00:00:00Z",
+				"last_error": "ramdisk unreachable"},
+			Options:           []WorkflowOption{WithStallTimeouts(map[nodes.ProvisionState]time.Duration{nodes.CleanWait: time.Hour}, true)},
+			ExpectedState:     "clean failed",
+			ExpectedLastError: "ramdisk unreachable",
+			ExpectedDetail:    "Provision state: clean failed\nTarget: provide\nRetries: 0\nLast error: ramdisk unreachable",
+		},
+		{
+			Scenario:           "unknown node",
+			ExpectedStatusCode: 404,
+			ExpectedDetail:     "Provision state: \nTarget: provide\nRetries: 0\nIronic API status: 404",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+			uuid := "c2e6ee59-7e48-4a3a-bff4-1b43ef4c5a21"
+			if tc.Node != nil {
+				tc.Node["driver"] = "fake-hardware"
+				uuid = fake.CreateNode(tc.Node)
+			}
+			for i := 0; i < tc.Failures; i++ {
+				fake.FailProvision(uuid, "provide", "cleaning failed")
+			}
+
+			options := append([]WorkflowOption{WithPollInterval(time.Millisecond)}, tc.Options...)
+			err := ChangeProvisionStateToTarget(context.Background(), fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil, options...)
+
+			var provisionErr *ProvisionError
+			if !errors.As(err, &provisionErr) {
+				t.Fatalf("expected a ProvisionError, got %v", err)
+			}
+			if tc.ExpectedCause != nil && !errors.Is(err, tc.ExpectedCause) {
+				t.Errorf("expected the cause to be '%v', got '%v'", tc.ExpectedCause, provisionErr.Err)
+			}
+			if provisionErr.NodeUUID != uuid || provisionErr.Target != nodes.TargetProvide {
+				t.Errorf("expected node %s and target 'provide', got %s and '%s'", uuid, provisionErr.NodeUUID, provisionErr.Target)
+			}
+			if provisionErr.State != tc.ExpectedState || provisionErr.LastError != tc.ExpectedLastError {
+				t.Errorf("expected state '%s' and last error '%s', got '%s' and '%s'", tc.ExpectedState, tc.ExpectedLastError,
+					provisionErr.State, provisionErr.LastError)
+			}
+			if provisionErr.Retries != tc.ExpectedRetries || provisionErr.StatusCode != tc.ExpectedStatusCode {
+				t.Errorf("expected %d retries and status %d, got %d and %d", tc.ExpectedRetries, tc.ExpectedStatusCode,
+					provisionErr.Retries, provisionErr.StatusCode)
+			}
+
+			// The diagnostics have the cause as summary, and the node in the detail
+			diags := provisionDiagnostics("could not provide", err, "available")
+			if len(diags) != 1 || diags[0].Summary != "could not provide: "+provisionErr.Err.Error() {
+				t.Fatalf("expected the summary to be the cause, got %v", diags)
+			}
+			if expected := fmt.Sprintf("Node: %s\n%s", uuid, tc.ExpectedDetail); diags[0].Detail != expected {
+				t.Errorf("expected the detail to be %q, got %q", expected, diags[0].Detail)
+			}
+			if !diags[0].AttributePath.Equals(cty.GetAttrPath("available")) {
+				t.Errorf("expected the diagnostics to point at 'available', got %v", diags[0].AttributePath)
+			}
+		})
+	}
+}
+
+func TestWorkflowOperationLimiter(t *testing.T) {
+	testCases := []struct {
+		Scenario      string
+		Max           int
+		PerGroup      map[string]int
+		HeldGroup     string
+		ExpectedCalls []string
+		ExpectedError string
+	}{
+		{
+			Scenario:      "wait for a free slot",
+			Max:           1,
+			ExpectedError: "stopped waiting for other operations to finish",
+		},
+		{
+			Scenario:      "wait for a free slot in the conductor group",
+			PerGroup:      map[string]int{"rack-1": 1},
+			HeldGroup:     "rack-1",
+			ExpectedError: "stopped waiting for other operations to finish",
+		},
+		{
+			Scenario:      "other conductor groups aren't limited",
+			PerGroup:      map[string]int{"rack-1": 1},
+			HeldGroup:     "rack-2",
+			ExpectedCalls: []string{"provide"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Scenario, func(t *testing.T) {
+			fake := th.NewFakeIronic()
+			defer fake.Close()
+			uuid := fake.CreateNode(map[string]interface{}{"driver": "fake-hardware", "provision_state": "manageable",
+				"conductor_group": "rack-1"})
+
+			// Another operation holds the only slot
+			limiter := NewOperationLimiter(tc.Max, tc.PerGroup)
+			release, err := limiter.Acquire(context.Background(), tc.HeldGroup)
+			th.AssertNoError(t, err)
+			defer release()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err = ChangeProvisionStateToTarget(ctx, fakeServiceClient(fake), uuid, nodes.TargetProvide, nil, nil, nil,
+				WithPollInterval(time.Millisecond), WithOperationLimiter(limiter))
+			if tc.ExpectedError != "" {
+				th.AssertError(t, err, tc.ExpectedError)
+			} else {
+				th.AssertNoError(t, err)
+			}
+
+			if calls := fake.ProvisionTargets(uuid); fmt.Sprint(calls) != fmt.Sprint(tc.ExpectedCalls) {
+				t.Errorf("expected the workflow to request %v, but it requested %v", tc.ExpectedCalls, calls)
+			}
+		})
+	}
+
+	// Slots are given back when operations finish
+	limiter := NewOperationLimiter(1, nil)
+	for i := 0; i < 3; i++ {
+		release, err := limiter.Acquire(context.Background(), "")
+		th.AssertNoError(t, err)
+		release()
+	}
+}
+
+// fakeServiceClient returns an Ironic client for the fake Ironic
+func fakeServiceClient(fake *th.FakeIronic) *gophercloud.ServiceClient {
+	return &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{},
+		Endpoint:       fake.IronicURL(),
+		Microversion:   th.FakeIronicMaxVersion,
+	}
+}
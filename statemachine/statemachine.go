@@ -0,0 +1,227 @@
+// Package statemachine describes Ironic's provision state machine as a table of the transitions that can be requested
+// through the API, and finds the transitions to request to move a node from one state to a target.
+package statemachine
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+)
+
+// States and targets gophercloud doesn't have constants for
+const (
+	CleanHold   nodes.ProvisionState = "clean hold"
+	DeployHold  nodes.ProvisionState = "deploy hold"
+	Servicing   nodes.ProvisionState = "servicing"
+	ServiceWait nodes.ProvisionState = "service wait"
+	ServiceFail nodes.ProvisionState = "service failed"
+	ServiceHold nodes.ProvisionState = "service hold"
+
+	TargetRebuild nodes.TargetProvisionState = "rebuild"
+	TargetService nodes.TargetProvisionState = "service"
+	TargetUnhold  nodes.TargetProvisionState = "unhold"
+)
+
+// Transition is a change of provision state that can be requested from a node in the From state. Ironic moves the
+// node through the Via states while it works, and leaves it in To when it succeeds or in one of the Failed states
+// when it doesn't.
+type Transition struct {
+	From   nodes.ProvisionState
+	Target nodes.TargetProvisionState
+	Via    []nodes.ProvisionState
+	To     nodes.ProvisionState
+	Failed []nodes.ProvisionState
+}
+
+// The states Ironic goes through for each kind of operation
+var (
+	verifying  = []nodes.ProvisionState{nodes.Verifying}
+	cleaning   = []nodes.ProvisionState{nodes.Cleaning, nodes.CleanWait}
+	inspecting = []nodes.ProvisionState{nodes.Inspecting, nodes.InspectWait}
+	deploying  = []nodes.ProvisionState{nodes.Deploying, nodes.DeployWait}
+	deleting   = []nodes.ProvisionState{nodes.Deleting, nodes.Cleaning, nodes.CleanWait}
+	adopting   = []nodes.ProvisionState{nodes.Adopting}
+	rescuing   = []nodes.ProvisionState{nodes.Rescuing, nodes.RescueWait}
+	unrescuing = []nodes.ProvisionState{nodes.Unrescuing}
+	servicing  = []nodes.ProvisionState{Servicing, ServiceWait}
+)
+
+// transitions is Ironic's state machine, from the point of view of an API user
+var transitions = []Transition{
+	{From: nodes.Enroll, Target: nodes.TargetManage, Via: verifying, To: nodes.Manageable, Failed: []nodes.ProvisionState{nodes.Enroll}},
+
+	{From: nodes.Manageable, Target: nodes.TargetProvide, Via: cleaning, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.CleanFail}},
+	{From: nodes.Manageable, Target: nodes.TargetClean, Via: cleaning, To: nodes.Manageable, Failed: []nodes.ProvisionState{nodes.CleanFail}},
+	{From: nodes.Manageable, Target: nodes.TargetInspect, Via: inspecting, To: nodes.Manageable, Failed: []nodes.ProvisionState{nodes.InspectFail}},
+	{From: nodes.Manageable, Target: nodes.TargetAdopt, Via: adopting, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.AdoptFail}},
+
+	{From: nodes.Available, Target: nodes.TargetManage, To: nodes.Manageable},
+	{From: nodes.Available, Target: nodes.TargetActive, Via: deploying, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.DeployFail}},
+
+	{From: nodes.Active, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+	{From: nodes.Active, Target: TargetRebuild, Via: deploying, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.DeployFail}},
+	{From: nodes.Active, Target: nodes.TargetRescue, Via: rescuing, To: nodes.Rescue, Failed: []nodes.ProvisionState{nodes.RescueFail}},
+	{From: nodes.Active, Target: TargetService, Via: servicing, To: nodes.Active, Failed: []nodes.ProvisionState{ServiceFail}},
+
+	{From: nodes.DeployWait, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+
+	{From: nodes.CleanWait, Target: nodes.TargetAbort, To: nodes.CleanFail},
+	{From: nodes.InspectWait, Target: nodes.TargetAbort, To: nodes.InspectFail},
+	{From: nodes.RescueWait, Target: nodes.TargetAbort, To: nodes.RescueFail},
+	{From: ServiceWait, Target: nodes.TargetAbort, To: ServiceFail},
+
+	{From: CleanHold, Target: TargetUnhold, Via: cleaning, To: nodes.Manageable, Failed: []nodes.ProvisionState{nodes.CleanFail}},
+	{From: CleanHold, Target: nodes.TargetAbort, To: nodes.CleanFail},
+	{From: DeployHold, Target: TargetUnhold, Via: deploying, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.DeployFail}},
+	{From: DeployHold, Target: nodes.TargetAbort, To: nodes.DeployFail},
+	{From: ServiceHold, Target: TargetUnhold, Via: servicing, To: nodes.Active, Failed: []nodes.ProvisionState{ServiceFail}},
+	{From: ServiceHold, Target: nodes.TargetAbort, To: ServiceFail},
+
+	{From: nodes.CleanFail, Target: nodes.TargetManage, To: nodes.Manageable},
+
+	{From: nodes.InspectFail, Target: nodes.TargetManage, To: nodes.Manageable},
+	{From: nodes.InspectFail, Target: nodes.TargetInspect, Via: inspecting, To: nodes.Manageable, Failed: []nodes.ProvisionState{nodes.InspectFail}},
+
+	{From: nodes.AdoptFail, Target: nodes.TargetManage, To: nodes.Manageable},
+	{From: nodes.AdoptFail, Target: nodes.TargetAdopt, Via: adopting, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.AdoptFail}},
+
+	{From: nodes.DeployFail, Target: nodes.TargetActive, Via: deploying, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.DeployFail}},
+	{From: nodes.DeployFail, Target: TargetRebuild, Via: deploying, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.DeployFail}},
+	{From: nodes.DeployFail, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+
+	{From: nodes.Error, Target: TargetRebuild, Via: deploying, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.DeployFail}},
+	{From: nodes.Error, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+
+	{From: nodes.Rescue, Target: nodes.TargetRescue, Via: rescuing, To: nodes.Rescue, Failed: []nodes.ProvisionState{nodes.RescueFail}},
+	{From: nodes.Rescue, Target: nodes.TargetUnrescue, Via: unrescuing, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.UnrescueFail}},
+	{From: nodes.Rescue, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+
+	{From: nodes.RescueFail, Target: nodes.TargetRescue, Via: rescuing, To: nodes.Rescue, Failed: []nodes.ProvisionState{nodes.RescueFail}},
+	{From: nodes.RescueFail, Target: nodes.TargetUnrescue, Via: unrescuing, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.UnrescueFail}},
+	{From: nodes.RescueFail, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+
+	{From: nodes.UnrescueFail, Target: nodes.TargetRescue, Via: rescuing, To: nodes.Rescue, Failed: []nodes.ProvisionState{nodes.RescueFail}},
+	{From: nodes.UnrescueFail, Target: nodes.TargetUnrescue, Via: unrescuing, To: nodes.Active, Failed: []nodes.ProvisionState{nodes.UnrescueFail}},
+	{From: nodes.UnrescueFail, Target: nodes.TargetDeleted, Via: deleting, To: nodes.Available, Failed: []nodes.ProvisionState{nodes.Error, nodes.CleanFail}},
+
+	{From: ServiceFail, Target: TargetService, Via: servicing, To: nodes.Active, Failed: []nodes.ProvisionState{ServiceFail}},
+	{From: ServiceFail, Target: nodes.TargetRescue, Via: rescuing, To: nodes.Rescue, Failed: []nodes.ProvisionState{nodes.RescueFail}},
+	{From: ServiceFail, Target: nodes.TargetAbort, To: nodes.Active},
+}
+
+// goals are the states in which a node already is where a target would take it, so nothing needs to be requested.
+// Targets that run an operation on the node, like clean or rebuild, don't have any.
+var goals = map[nodes.TargetProvisionState][]nodes.ProvisionState{
+	nodes.TargetManage:   {nodes.Manageable},
+	nodes.TargetProvide:  {nodes.Available},
+	nodes.TargetActive:   {nodes.Active},
+	nodes.TargetAdopt:    {nodes.Active},
+	nodes.TargetRescue:   {nodes.Rescue},
+	nodes.TargetUnrescue: {nodes.Active},
+	nodes.TargetDeleted:  {nodes.Available, nodes.Manageable, nodes.Enroll},
+}
+
+// preparatory are the targets requested on the way to another target. They only move a node between the states it
+// has without an instance; anything else changes what runs on the node, and is only requested as the target itself.
+var preparatory = map[nodes.TargetProvisionState]bool{
+	nodes.TargetManage:  true,
+	nodes.TargetProvide: true,
+}
+
+// NoPathError is returned when a node can't be moved from its state to the target
+type NoPathError struct {
+	State  nodes.ProvisionState
+	Target nodes.TargetProvisionState
+}
+
+func (e *NoPathError) Error() string {
+	return fmt.Sprintf("cannot reach target '%s' from state '%s'", e.Target, e.State)
+}
+
+// Transitions returns the transitions that can be requested from Ironic
+func Transitions() []Transition {
+	return append([]Transition(nil), transitions...)
+}
+
+// Find returns the transition to the target from the state, if Ironic allows it
+func Find(state nodes.ProvisionState, target nodes.TargetProvisionState) (Transition, bool) {
+	for _, transition := range transitions {
+		if transition.From == state && transition.Target == target {
+			return transition, true
+		}
+	}
+	return Transition{}, false
+}
+
+// Reached returns whether a node in the state is already where the target would take it
+func Reached(state nodes.ProvisionState, target nodes.TargetProvisionState) bool {
+	return contains(goals[target], state)
+}
+
+// IsTransient returns whether Ironic is working on a node in the state, and will move it to another one by itself
+func IsTransient(state nodes.ProvisionState) bool {
+	for _, transition := range transitions {
+		if contains(transition.Via, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFailure returns whether the state is one Ironic leaves a node in when an operation fails
+func IsFailure(state nodes.ProvisionState) bool {
+	for _, transition := range transitions {
+		if contains(transition.Failed, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the transitions to request, in order, to move a node from the state to the target. The path is empty
+// when the node has already reached the target. Only manage and provide are requested on the way, the last
+// transition is the target itself unless a state the target leads to is reached before.
+func Path(state nodes.ProvisionState, target nodes.TargetProvisionState) ([]Transition, error) {
+	if Reached(state, target) {
+		return nil, nil
+	}
+
+	// Breadth-first search, so the path is the shortest one
+	paths := map[nodes.ProvisionState][]Transition{state: nil}
+	queue := []nodes.ProvisionState{state}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if transition, ok := Find(current, target); ok {
+			return append(append([]Transition(nil), paths[current]...), transition), nil
+		}
+
+		for _, transition := range transitions {
+			if transition.From != current || !preparatory[transition.Target] {
+				continue
+			}
+			if _, seen := paths[transition.To]; seen {
+				continue
+			}
+
+			path := append(append([]Transition(nil), paths[current]...), transition)
+			if Reached(transition.To, target) {
+				return path, nil
+			}
+			paths[transition.To] = path
+			queue = append(queue, transition.To)
+		}
+	}
+
+	return nil, &NoPathError{State: state, Target: target}
+}
+
+func contains(states []nodes.ProvisionState, state nodes.ProvisionState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/baremetal/v1/nodes"
+)
+
+func TestTransitions(t *testing.T) {
+	edges := []struct {
+		From   nodes.ProvisionState
+		Target nodes.TargetProvisionState
+		To     nodes.ProvisionState
+	}{
+		{"enroll", "manage", "manageable"},
+		{"manageable", "provide", "available"},
+		{"manageable", "clean", "manageable"},
+		{"manageable", "inspect", "manageable"},
+		{"manageable", "adopt", "active"},
+		{"available", "manage", "manageable"},
+		{"available", "active", "active"},
+		{"active", "deleted", "available"},
+		{"active", "rebuild", "active"},
+		{"active", "rescue", "rescue"},
+		{"active", "service", "active"},
+		{"wait call-back", "deleted", "available"},
+		{"clean wait", "abort", "clean failed"},
+		{"inspect wait", "abort", "inspect failed"},
+		{"rescue wait", "abort", "rescue failed"},
+		{"service wait", "abort", "service failed"},
+		{"clean hold", "unhold", "manageable"},
+		{"clean hold", "abort", "clean failed"},
+		{"deploy hold", "unhold", "active"},
+		{"deploy hold", "abort", "deploy failed"},
+		{"service hold", "unhold", "active"},
+		{"service hold", "abort", "service failed"},
+		{"clean failed", "manage", "manageable"},
+		{"inspect failed", "manage", "manageable"},
+		{"inspect failed", "inspect", "manageable"},
+		{"adopt failed", "manage", "manageable"},
+		{"adopt failed", "adopt", "active"},
+		{"deploy failed", "active", "active"},
+		{"deploy failed", "rebuild", "active"},
+		{"deploy failed", "deleted", "available"},
+		{"error", "rebuild", "active"},
+		{"error", "deleted", "available"},
+		{"rescue", "rescue", "rescue"},
+		{"rescue", "unrescue", "active"},
+		{"rescue", "deleted", "available"},
+		{"rescue failed", "rescue", "rescue"},
+		{"rescue failed", "unrescue", "active"},
+		{"rescue failed", "deleted", "available"},
+		{"unrescue failed", "rescue", "rescue"},
+		{"unrescue failed", "unrescue", "active"},
+		{"unrescue failed", "deleted", "available"},
+		{"service failed", "service", "active"},
+		{"service failed", "rescue", "rescue"},
+		{"service failed", "abort", "active"},
+	}
+
+	if len(Transitions()) != len(edges) {
+		t.Fatalf("expected %d transitions, the table has %d", len(edges), len(Transitions()))
+	}
+
+	for _, edge := range edges {
+		t.Run(fmt.Sprintf("%s %s", edge.From, edge.Target), func(t *testing.T) {
+			transition, ok := Find(edge.From, edge.Target)
+			if !ok {
+				t.Fatalf("transition not found")
+			}
+			if transition.To != edge.To {
+				t.Errorf("expected the transition to lead to '%s', got '%s'", edge.To, transition.To)
+			}
+
+			// Ironic passes through working states, and stops in states that need a request to move on
+			for _, state := range transition.Via {
+				if !IsTransient(state) {
+					t.Errorf("expected '%s' to be transient", state)
+				}
+			}
+			if IsTransient(transition.To) {
+				t.Errorf("expected '%s' not to be transient", transition.To)
+			}
+			for _, state := range transition.Failed {
+				if IsTransient(state) || !IsFailure(state) {
+					t.Errorf("expected '%s' to be a failure state", state)
+				}
+			}
+
+			// A single request is the shortest path to the target, unless the node is already there
+			if !Reached(edge.From, edge.Target) {
+				path, err := Path(edge.From, edge.Target)
+				if err != nil {
+					t.Fatalf("got unexpected error: %v", err)
+				}
+				if len(path) != 1 || path[0].Target != edge.Target {
+					t.Errorf("expected the path to be the transition itself, got %v", path)
+				}
+			}
+		})
+	}
+}
+
+func TestFindUnknownTransition(t *testing.T) {
+	if transition, ok := Find(nodes.Active, nodes.TargetManage); ok {
+		t.Errorf("expected no transition, got %v", transition)
+	}
+}
+
+func TestPath(t *testing.T) {
+	cases := []struct {
+		State    nodes.ProvisionState
+		Target   nodes.TargetProvisionState
+		Expected []nodes.TargetProvisionState
+		Error    bool
+	}{
+		{State: "available", Target: "provide", Expected: nil},
+		{State: "active", Target: "active", Expected: nil},
+		{State: "active", Target: "adopt", Expected: nil},
+		{State: "active", Target: "unrescue", Expected: nil},
+		{State: "enroll", Target: "deleted", Expected: nil},
+		{State: "manageable", Target: "deleted", Expected: nil},
+		{State: "enroll", Target: "provide", Expected: []nodes.TargetProvisionState{"manage", "provide"}},
+		{State: "enroll", Target: "active", Expected: []nodes.TargetProvisionState{"manage", "provide", "active"}},
+		{State: "enroll", Target: "inspect", Expected: []nodes.TargetProvisionState{"manage", "inspect"}},
+		{State: "enroll", Target: "adopt", Expected: []nodes.TargetProvisionState{"manage", "adopt"}},
+		{State: "available", Target: "clean", Expected: []nodes.TargetProvisionState{"manage", "clean"}},
+		{State: "available", Target: "adopt", Expected: []nodes.TargetProvisionState{"manage", "adopt"}},
+		{State: "manageable", Target: "active", Expected: []nodes.TargetProvisionState{"provide", "active"}},
+		{State: "clean failed", Target: "provide", Expected: []nodes.TargetProvisionState{"manage", "provide"}},
+		{State: "clean failed", Target: "deleted", Expected: []nodes.TargetProvisionState{"manage"}},
+		{State: "inspect failed", Target: "active", Expected: []nodes.TargetProvisionState{"manage", "provide", "active"}},
+		{State: "adopt failed", Target: "deleted", Expected: []nodes.TargetProvisionState{"manage"}},
+		{State: "clean wait", Target: "abort", Expected: []nodes.TargetProvisionState{"abort"}},
+		// The path never tears down or deploys an instance on the way
+		{State: "active", Target: "manage", Error: true},
+		{State: "active", Target: "provide", Error: true},
+		{State: "rescue", Target: "active", Error: true},
+		{State: "available", Target: "rebuild", Error: true},
+		{State: "error", Target: "active", Error: true},
+		{State: "available", Target: "rescue", Error: true},
+		{State: "clean hold", Target: "clean", Error: true},
+		{State: "cleaning", Target: "provide", Error: true},
+		{State: "available", Target: "nonsense", Error: true},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s to %s", c.State, c.Target), func(t *testing.T) {
+			path, err := Path(c.State, c.Target)
+			if c.Error {
+				var noPath *NoPathError
+				if !errors.As(err, &noPath) || noPath.State != c.State || noPath.Target != c.Target {
+					t.Fatalf("expected a NoPathError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+
+			var targets []nodes.TargetProvisionState
+			for _, transition := range path {
+				targets = append(targets, transition.Target)
+			}
+			if fmt.Sprint(targets) != fmt.Sprint(c.Expected) {
+				t.Errorf("expected the path %v, got %v", c.Expected, targets)
+			}
+		})
+	}
+}
+
+func TestStateKinds(t *testing.T) {
+	for _, state := range []nodes.ProvisionState{"verifying", "cleaning", "clean wait", "inspecting", "inspect wait", "deploying",
+		"wait call-back", "deleting", "adopting", "rescuing", "rescue wait", "unrescuing", "servicing", "service wait"} {
+		if !IsTransient(state) {
+			t.Errorf("expected '%s' to be transient", state)
+		}
+	}
+
+	for _, state := range []nodes.ProvisionState{"enroll", "clean failed", "inspect failed", "deploy failed", "error", "adopt failed",
+		"rescue failed", "unrescue failed", "service failed"} {
+		if !IsFailure(state) {
+			t.Errorf("expected '%s' to be a failure state", state)
+		}
+	}
+
+	for _, state := range []nodes.ProvisionState{"manageable", "available", "active", "rescue", "clean hold", "deploy hold", "service hold"} {
+		if IsTransient(state) || IsFailure(state) {
+			t.Errorf("expected '%s' to be neither transient nor a failure state", state)
+		}
+	}
+}
@@ -0,0 +1,1139 @@
+package testhelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// FakeIronicMinVersion is the oldest Ironic API version advertised by FakeIronic
+	FakeIronicMinVersion = "1.1"
+
+	// FakeIronicMaxVersion is the newest Ironic API version advertised by FakeIronic
+	FakeIronicMaxVersion = "1.81"
+)
+
+// FakeIronic is an in-process simulation of the Ironic and Ironic Inspector APIs, so the provider can be tested
+// without a real Ironic. It keeps nodes, ports and allocations in memory, and models the provision state machine and
+// power state. Long-running operations pass through Ironic's intermediate states, advancing by one state every time
+// the node is fetched. Failures and 409 busy responses can be injected with FailProvision and SetBusy.
+type FakeIronic struct {
+	// Ironic serves the bare metal API, the provider's url is IronicURL()
+	Ironic *httptest.Server
+
+	// Inspector serves the introspection API, the provider's inspector url is InspectorURL()
+	Inspector *httptest.Server
+
+	mu            sync.Mutex
+	nodes         map[string]*fakeNode
+	ports         map[string]map[string]interface{}
+	allocations   map[string]map[string]interface{}
+	introspection map[string]*fakeIntrospection
+}
+
+type fakeNode struct {
+	fields map[string]interface{}
+
+	// The operation in progress, if any: its remaining intermediate states, and the states it ends in
+	operating bool
+	pending   []string
+	done      string
+	failed    string
+	lastError string
+
+	// Failure messages to inject, consumed by the next operations with the given provision state target
+	failures map[string][]string
+
+	// Number of upcoming write requests to reject with 409 Conflict
+	busy int
+
+	// Provision state targets requested, in order
+	targets []string
+
+	// Clean steps of each manual cleaning requested, in order
+	cleanSteps [][]interface{}
+
+	// BIOS settings, as cached by Ironic, and the details of the ones that have some
+	bios        map[string]string
+	biosDetails map[string]map[string]interface{}
+}
+
+type fakeIntrospection struct {
+	status map[string]interface{}
+	data   map[string]interface{}
+}
+
+// provisionTransition describes how Ironic moves a node from a stable state when asked for a target
+type provisionTransition struct {
+	steps  []string
+	done   string
+	failed string
+}
+
+type transitionKey struct {
+	state  string
+	target string
+}
+
+// provisionTransitions is the subset of Ironic's state machine modelled by FakeIronic
+var provisionTransitions = map[transitionKey]provisionTransition{
+	{"enroll", "manage"}:            {[]string{"verifying"}, "manageable", "enroll"},
+	{"manageable", "provide"}:       {[]string{"cleaning", "clean wait"}, "available", "clean failed"},
+	{"manageable", "clean"}:         {[]string{"cleaning", "clean wait"}, "manageable", "clean failed"},
+	{"manageable", "inspect"}:       {[]string{"inspecting", "inspect wait"}, "manageable", "inspect failed"},
+	{"manageable", "adopt"}:         {[]string{"adopting"}, "active", "adopt failed"},
+	{"available", "manage"}:         {nil, "manageable", ""},
+	{"available", "active"}:         {[]string{"deploying", "wait call-back"}, "active", "deploy failed"},
+	{"active", "deleted"}:           {[]string{"deleting", "cleaning"}, "available", "clean failed"},
+	{"active", "rebuild"}:           {[]string{"deploying", "wait call-back"}, "active", "deploy failed"},
+	{"deploy failed", "rebuild"}:    {[]string{"deploying", "wait call-back"}, "active", "deploy failed"},
+	{"active", "rescue"}:            {[]string{"rescuing", "rescue wait"}, "rescue", "rescue failed"},
+	{"rescue", "unrescue"}:          {[]string{"unrescuing"}, "active", "unrescue failed"},
+	{"rescue", "deleted"}:           {[]string{"deleting", "cleaning"}, "available", "clean failed"},
+	{"rescue failed", "unrescue"}:   {[]string{"unrescuing"}, "active", "unrescue failed"},
+	{"rescue failed", "deleted"}:    {[]string{"deleting", "cleaning"}, "available", "clean failed"},
+	{"unrescue failed", "unrescue"}: {[]string{"unrescuing"}, "active", "unrescue failed"},
+	{"wait call-back", "deleted"}:   {[]string{"deleting", "cleaning"}, "available", "clean failed"},
+	{"deploy failed", "active"}:     {[]string{"deploying", "wait call-back"}, "active", "deploy failed"},
+	{"deploy failed", "deleted"}:    {[]string{"deleting", "cleaning"}, "available", "clean failed"},
+	{"error", "deleted"}:            {[]string{"deleting", "cleaning"}, "available", "clean failed"},
+	{"clean failed", "manage"}:      {nil, "manageable", ""},
+	{"inspect failed", "manage"}:    {nil, "manageable", ""},
+	{"adopt failed", "manage"}:      {nil, "manageable", ""},
+	{"adopt failed", "adopt"}:       {[]string{"adopting"}, "active", "adopt failed"},
+	{"clean wait", "abort"}:         {nil, "clean failed", ""},
+	{"inspect wait", "abort"}:       {nil, "inspect failed", ""},
+	{"rescue wait", "abort"}:        {nil, "rescue failed", ""},
+}
+
+// Power state targets, and the power state the node ends up in
+var powerTransitions = map[string]string{
+	"power on":       "power on",
+	"power off":      "power off",
+	"rebooting":      "power on",
+	"soft power off": "power off",
+	"soft rebooting": "power on",
+}
+
+// The fields of a port, patching any other field is refused like Ironic does
+var portFields = []string{"uuid", "address", "node_uuid", "portgroup_uuid", "local_link_connection", "pxe_enabled",
+	"physical_network", "extra", "is_smartnic", "internal_info", "created_at", "updated_at"}
+
+// NewFakeIronic starts the fake Ironic and Inspector servers. Call Close when done.
+func NewFakeIronic() *FakeIronic {
+	f := &FakeIronic{
+		nodes:         make(map[string]*fakeNode),
+		ports:         make(map[string]map[string]interface{}),
+		allocations:   make(map[string]map[string]interface{}),
+		introspection: make(map[string]*fakeIntrospection),
+	}
+	f.Ironic = httptest.NewServer(http.HandlerFunc(f.serveIronic))
+	f.Inspector = httptest.NewServer(http.HandlerFunc(f.serveInspector))
+
+	return f
+}
+
+// Close shuts down the fake servers
+func (f *FakeIronic) Close() {
+	f.Ironic.Close()
+	f.Inspector.Close()
+}
+
+// IronicURL returns the Ironic endpoint, as configured in the provider
+func (f *FakeIronic) IronicURL() string {
+	return f.Ironic.URL + "/v1/"
+}
+
+// InspectorURL returns the Inspector endpoint, as configured in the provider
+func (f *FakeIronic) InspectorURL() string {
+	return f.Inspector.URL + "/v1/"
+}
+
+// CreateNode adds a node directly, without going through the API. Fields not given get Ironic's defaults, e.g. a
+// provision_state of "enroll". It returns the node's UUID.
+func (f *FakeIronic) CreateNode(fields map[string]interface{}) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.addNode(copyMap(fields)).fields["uuid"].(string)
+}
+
+// Node returns a copy of the node with the given UUID or name, or nil if it doesn't exist. Unlike the API, secrets in
+// driver_info are not masked.
+func (f *FakeIronic) Node(id string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	node := f.findNode(id)
+	if node == nil {
+		return nil
+	}
+	return copyMap(node.fields)
+}
+
+// Port returns a copy of the port with the given UUID, or nil if it doesn't exist
+func (f *FakeIronic) Port(id string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if port, ok := f.ports[id]; ok {
+		return copyMap(port)
+	}
+	return nil
+}
+
+// Allocation returns a copy of the allocation with the given UUID or name, or nil if it doesn't exist
+func (f *FakeIronic) Allocation(id string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if allocation := f.findAllocation(id); allocation != nil {
+		return copyMap(allocation)
+	}
+	return nil
+}
+
+// ProvisionTargets returns the provision state targets requested for the node, in order
+func (f *FakeIronic) ProvisionTargets(id string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		return append([]string(nil), node.targets...)
+	}
+	return nil
+}
+
+// CleanSteps returns the clean steps of each manual cleaning requested for the node, in order
+func (f *FakeIronic) CleanSteps(id string) [][]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		return append([][]interface{}(nil), node.cleanSteps...)
+	}
+	return nil
+}
+
+// SetBIOSSettings changes the node's BIOS settings, e.g. to simulate changes made outside of Ironic
+func (f *FakeIronic) SetBIOSSettings(id string, settings map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		for name, value := range settings {
+			node.bios[name] = value
+		}
+	}
+}
+
+// SetBIOSSettingDetails sets the details returned for a BIOS setting of the node when listing them with detail,
+// e.g. allowable_values or read_only
+func (f *FakeIronic) SetBIOSSettingDetails(id, name string, details map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		node.biosDetails[name] = details
+	}
+}
+
+// SetProvisionState moves the node to the given state, cancelling any operation in progress
+func (f *FakeIronic) SetProvisionState(id, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		node.operating = false
+		node.fields["target_provision_state"] = nil
+		node.setProvisionState(state)
+	}
+}
+
+// FailProvision makes the next operation on the node for the given provision state target (e.g. "provide") fail,
+// leaving the node in the corresponding failed state with the message as its last_error. Call it repeatedly to fail
+// several attempts.
+func (f *FakeIronic) FailProvision(id, target, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		node.failures[target] = append(node.failures[target], message)
+	}
+}
+
+// SetBusy makes the next count write requests for the node fail with 409 Conflict, as Ironic does when a node is
+// locked by a conductor
+func (f *FakeIronic) SetBusy(id string, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if node := f.findNode(id); node != nil {
+		node.busy = count
+	}
+}
+
+// SetIntrospectionData sets the data returned by Inspector for the node once it has been inspected
+func (f *FakeIronic) SetIntrospectionData(id string, data map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.introspectionFor(id).data = copyMap(data)
+}
+
+func (f *FakeIronic) serveIronic(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("X-OpenStack-Ironic-API-Minimum-Version", FakeIronicMinVersion)
+	w.Header().Set("X-OpenStack-Ironic-API-Maximum-Version", FakeIronicMaxVersion)
+
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" || path == "v1" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id": "v1",
+			"version": map[string]interface{}{
+				"id":          "v1",
+				"status":      "CURRENT",
+				"version":     FakeIronicMaxVersion,
+				"min_version": FakeIronicMinVersion,
+			},
+		})
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "v1/"), "/")
+	switch parts[0] {
+	case "drivers":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"drivers": []map[string]interface{}{
+				{"name": "fake-hardware", "hosts": []string{"fake-conductor"}, "type": "dynamic"},
+			},
+		})
+	case "nodes":
+		f.serveNodes(w, r, parts[1:])
+	case "ports":
+		f.servePorts(w, r, parts[1:])
+	case "allocations":
+		f.serveAllocations(w, r, parts[1:])
+	default:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+	}
+}
+
+func (f *FakeIronic) serveNodes(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 || parts[0] == "detail" {
+		switch r.Method {
+		case http.MethodGet:
+			var list []map[string]interface{}
+			for _, uuid := range sortedKeys(f.nodes) {
+				list = append(list, f.nodes[uuid].render())
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"nodes": list})
+		case http.MethodPost:
+			var fields map[string]interface{}
+			if !readJSON(w, r, &fields) {
+				return
+			}
+			if driver, _ := fields["driver"].(string); driver == "" {
+				writeError(w, http.StatusBadRequest, "Mandatory field missing: 'driver'")
+				return
+			}
+			if _, ok := fields["traits"]; ok {
+				writeError(w, http.StatusBadRequest, "Cannot specify node traits on node creation. Use the node traits API.")
+				return
+			}
+			if name, _ := fields["name"].(string); name != "" && f.findNode(name) != nil {
+				writeError(w, http.StatusConflict, "A node with name %s already exists.", name)
+				return
+			}
+			writeJSON(w, http.StatusCreated, f.addNode(fields).render())
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	node := f.findNode(parts[0])
+	if node == nil {
+		writeError(w, http.StatusNotFound, "Node %s could not be found.", parts[0])
+		return
+	}
+	uuid := node.fields["uuid"].(string)
+
+	if r.Method != http.MethodGet && node.busy > 0 {
+		node.busy--
+		writeError(w, http.StatusConflict, "Node %s is locked by host fake-conductor, please retry after the current operation is completed.", uuid)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, node.render())
+		f.advance(node)
+	case len(parts) == 1 && r.Method == http.MethodPatch:
+		var patch []map[string]interface{}
+		if !readJSON(w, r, &patch) {
+			return
+		}
+		if err := applyPatch(node.fields, patch, "uuid", "provision_state", "target_provision_state", "power_state",
+			"target_power_state", "last_error", "allocation_uuid", "traits"); err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		node.touch()
+		writeJSON(w, http.StatusOK, node.render())
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		switch node.fields["provision_state"] {
+		case "enroll", "manageable", "available", "inspect failed", "clean failed", "adopt failed":
+		default:
+			writeError(w, http.StatusBadRequest, "Node %s can not be deleted while it is in state %s.", uuid, node.fields["provision_state"])
+			return
+		}
+		for id, port := range f.ports {
+			if port["node_uuid"] == uuid {
+				delete(f.ports, id)
+			}
+		}
+		delete(f.nodes, uuid)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[1] == "states" && r.Method == http.MethodPut:
+		var body map[string]interface{}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		switch parts[2] {
+		case "provision":
+			f.changeProvisionState(w, node, body)
+		case "power":
+			f.changePowerState(w, node, body)
+		case "raid":
+			node.fields["target_raid_config"] = body
+			node.touch()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusNotFound, "The resource could not be found.")
+		}
+	case len(parts) == 2 && parts[1] == "maintenance" && r.Method == http.MethodPut:
+		var body map[string]interface{}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		node.fields["maintenance"] = true
+		node.fields["maintenance_reason"] = body["reason"]
+		node.touch()
+		w.WriteHeader(http.StatusAccepted)
+	case len(parts) == 2 && parts[1] == "maintenance" && r.Method == http.MethodDelete:
+		node.fields["maintenance"] = false
+		node.fields["maintenance_reason"] = nil
+		node.fields["fault"] = nil
+		node.touch()
+		w.WriteHeader(http.StatusAccepted)
+	case len(parts) == 2 && parts[1] == "bios" && r.Method == http.MethodGet:
+		detail := r.URL.Query().Get("detail") == "true"
+		var settings []map[string]interface{}
+		for _, name := range sortedKeys(node.bios) {
+			setting := map[string]interface{}{"name": name, "value": node.bios[name]}
+			if detail {
+				for _, field := range []string{"attribute_type", "allowable_values", "lower_bound", "upper_bound",
+					"min_length", "max_length", "read_only", "reset_required", "unique"} {
+					setting[field] = node.biosDetails[name][field]
+				}
+			}
+			settings = append(settings, setting)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"bios": settings})
+	case len(parts) >= 2 && parts[1] == "traits":
+		f.serveTraits(w, r, node, parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+	}
+}
+
+// Serve the node traits API, /v1/nodes/{node}/traits and /v1/nodes/{node}/traits/{trait}
+func (f *FakeIronic) serveTraits(w http.ResponseWriter, r *http.Request, node *fakeNode, parts []string) {
+	traits, _ := node.fields["traits"].([]interface{})
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"traits": traits})
+		return
+	case len(parts) == 0 && r.Method == http.MethodPut:
+		var body struct {
+			Traits []interface{} `json:"traits"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		traits = body.Traits
+	case len(parts) == 0 && r.Method == http.MethodDelete:
+		traits = nil
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		if !containsAny(traits, parts[0]) {
+			traits = append(traits, parts[0])
+		}
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if !containsAny(traits, parts[0]) {
+			writeError(w, http.StatusNotFound, "Node %s doesn't have a trait '%s'", node.fields["uuid"], parts[0])
+			return
+		}
+		var remaining []interface{}
+		for _, trait := range traits {
+			if trait != parts[0] {
+				remaining = append(remaining, trait)
+			}
+		}
+		traits = remaining
+	default:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+
+	if traits == nil {
+		traits = []interface{}{}
+	}
+	node.fields["traits"] = traits
+	node.touch()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Start a provision state change on the node, as requested by PUT /v1/nodes/{node}/states/provision
+func (f *FakeIronic) changeProvisionState(w http.ResponseWriter, node *fakeNode, body map[string]interface{}) {
+	target, _ := body["target"].(string)
+	state, _ := node.fields["provision_state"].(string)
+	node.targets = append(node.targets, target)
+
+	transition, ok := provisionTransitions[transitionKey{state, target}]
+	if !ok || (node.operating && target != "abort") {
+		writeError(w, http.StatusBadRequest, "The requested action \"%s\" can not be performed on node \"%s\" while it is in state \"%s\".",
+			target, node.fields["uuid"], state)
+		return
+	}
+	if password, _ := body["rescue_password"].(string); target == "rescue" && password == "" {
+		writeError(w, http.StatusBadRequest, "A non-empty 'rescue_password' is required when setting target provision state to rescue.")
+		return
+	}
+
+	node.operating = true
+	node.pending = transition.steps
+	node.done = transition.done
+	node.failed = transition.failed
+	node.lastError = ""
+	if failures := node.failures[target]; len(failures) > 0 {
+		node.lastError = failures[0]
+		node.failures[target] = failures[1:]
+	}
+	node.fields["last_error"] = nil
+	node.fields["target_provision_state"] = transition.done
+
+	switch target {
+	case "active", "rebuild":
+		instanceInfo, _ := node.fields["instance_info"].(map[string]interface{})
+		if instanceInfo == nil {
+			instanceInfo = make(map[string]interface{})
+		}
+		if configDrive, ok := body["configdrive"]; ok {
+			instanceInfo["configdrive"] = configDrive
+		}
+		node.fields["instance_info"] = instanceInfo
+	case "inspect":
+		introspection := f.introspectionFor(node.fields["uuid"].(string))
+		introspection.status["finished"] = false
+		introspection.status["state"] = "waiting"
+		introspection.status["error"] = nil
+		introspection.status["started_at"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+		introspection.status["finished_at"] = nil
+		node.fields["inspection_started_at"] = time.Now().UTC().Format(time.RFC3339)
+		node.fields["inspection_finished_at"] = nil
+	case "clean":
+		steps, _ := body["clean_steps"].([]interface{})
+		node.cleanSteps = append(node.cleanSteps, steps)
+		node.applyCleanSteps(steps)
+	case "rescue":
+		instanceInfo, _ := node.fields["instance_info"].(map[string]interface{})
+		if instanceInfo == nil {
+			instanceInfo = make(map[string]interface{})
+		}
+		instanceInfo["rescue_password"] = body["rescue_password"]
+		node.fields["instance_info"] = instanceInfo
+	case "unrescue":
+		if instanceInfo, ok := node.fields["instance_info"].(map[string]interface{}); ok {
+			delete(instanceInfo, "rescue_password")
+		}
+	case "abort":
+		node.pending = nil
+	}
+
+	f.advance(node)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Change the node's power state, as requested by PUT /v1/nodes/{node}/states/power. The change completes
+// immediately.
+func (f *FakeIronic) changePowerState(w http.ResponseWriter, node *fakeNode, body map[string]interface{}) {
+	target, _ := body["target"].(string)
+	powerState, ok := powerTransitions[target]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid power state %s.", target)
+		return
+	}
+
+	node.fields["power_state"] = powerState
+	node.fields["target_power_state"] = nil
+	node.touch()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Move the node's operation in progress on by one state
+func (f *FakeIronic) advance(node *fakeNode) {
+	if !node.operating {
+		return
+	}
+
+	if len(node.pending) > 0 {
+		node.setProvisionState(node.pending[0])
+		node.pending = node.pending[1:]
+		return
+	}
+
+	node.operating = false
+	node.fields["target_provision_state"] = nil
+	uuid := node.fields["uuid"].(string)
+
+	if node.lastError != "" && node.failed != "" {
+		node.fields["last_error"] = node.lastError
+		node.setProvisionState(node.failed)
+		if introspection, ok := f.introspection[uuid]; ok && node.failed == "inspect failed" {
+			introspection.status["finished"] = true
+			introspection.status["state"] = "error"
+			introspection.status["error"] = node.lastError
+			introspection.status["finished_at"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+			node.fields["inspection_finished_at"] = time.Now().UTC().Format(time.RFC3339)
+		}
+		return
+	}
+
+	switch state, _ := node.fields["provision_state"].(string); {
+	case state == "inspect wait":
+		introspection := f.introspectionFor(uuid)
+		introspection.status["finished"] = true
+		introspection.status["state"] = "finished"
+		introspection.status["finished_at"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+		node.fields["inspection_finished_at"] = time.Now().UTC().Format(time.RFC3339)
+	case state == "cleaning" && node.done == "available":
+		// Undeploying clears the instance
+		node.fields["instance_info"] = map[string]interface{}{}
+		node.fields["instance_uuid"] = nil
+	}
+	node.setProvisionState(node.done)
+}
+
+func (f *FakeIronic) servePorts(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 || parts[0] == "detail" {
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			var list []map[string]interface{}
+			for _, id := range sortedKeys(f.ports) {
+				port := f.ports[id]
+				if address := query.Get("address"); address != "" && !strings.EqualFold(port["address"].(string), address) {
+					continue
+				}
+				if nodeID := query.Get("node"); nodeID != "" {
+					if node := f.findNode(nodeID); node == nil || port["node_uuid"] != node.fields["uuid"] {
+						continue
+					}
+				}
+				if nodeUUID := query.Get("node_uuid"); nodeUUID != "" && port["node_uuid"] != nodeUUID {
+					continue
+				}
+				list = append(list, port)
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"ports": list})
+		case http.MethodPost:
+			var fields map[string]interface{}
+			if !readJSON(w, r, &fields) {
+				return
+			}
+			nodeUUID, _ := fields["node_uuid"].(string)
+			if f.findNode(nodeUUID) == nil {
+				writeError(w, http.StatusBadRequest, "Node %s could not be found.", nodeUUID)
+				return
+			}
+			address, _ := fields["address"].(string)
+			if address == "" {
+				writeError(w, http.StatusBadRequest, "Mandatory field missing: 'address'")
+				return
+			}
+			for _, port := range f.ports {
+				if strings.EqualFold(port["address"].(string), address) {
+					writeError(w, http.StatusConflict, "A port with MAC address %s already exists.", address)
+					return
+				}
+			}
+			port := map[string]interface{}{
+				"uuid":                  uuid.New().String(),
+				"pxe_enabled":           true,
+				"is_smartnic":           false,
+				"local_link_connection": map[string]interface{}{},
+				"extra":                 map[string]interface{}{},
+				"internal_info":         map[string]interface{}{},
+				"created_at":            time.Now().UTC().Format(time.RFC3339),
+			}
+			for k, v := range fields {
+				port[k] = v
+			}
+			f.ports[port["uuid"].(string)] = port
+			writeJSON(w, http.StatusCreated, port)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	port, ok := f.ports[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Port %s could not be found.", parts[0])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, port)
+	case http.MethodPatch:
+		var patch []map[string]interface{}
+		if !readJSON(w, r, &patch) {
+			return
+		}
+		if err := checkPatchFields(patch, portFields); err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		if err := applyPatch(port, patch, "uuid", "node_uuid"); err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		port["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+		writeJSON(w, http.StatusOK, port)
+	case http.MethodDelete:
+		delete(f.ports, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (f *FakeIronic) serveAllocations(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			var list []map[string]interface{}
+			for _, id := range sortedKeys(f.allocations) {
+				list = append(list, f.allocations[id])
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"allocations": list})
+		case http.MethodPost:
+			var fields map[string]interface{}
+			if !readJSON(w, r, &fields) {
+				return
+			}
+			if class, _ := fields["resource_class"].(string); class == "" {
+				writeError(w, http.StatusBadRequest, "Mandatory field missing: 'resource_class'")
+				return
+			}
+			allocation := map[string]interface{}{
+				"uuid":            uuid.New().String(),
+				"state":           "allocating",
+				"node_uuid":       nil,
+				"last_error":      nil,
+				"candidate_nodes": []interface{}{},
+				"traits":          []interface{}{},
+				"extra":           map[string]interface{}{},
+				"created_at":      time.Now().UTC().Format(time.RFC3339),
+			}
+			for k, v := range fields {
+				allocation[k] = v
+			}
+			f.allocations[allocation["uuid"].(string)] = allocation
+			writeJSON(w, http.StatusCreated, allocation)
+
+			// Ironic allocates asynchronously, the result is only visible on the next request
+			f.allocate(allocation)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	allocation := f.findAllocation(parts[0])
+	if allocation == nil {
+		writeError(w, http.StatusNotFound, "Allocation %s could not be found.", parts[0])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, allocation)
+	case http.MethodDelete:
+		if nodeUUID, _ := allocation["node_uuid"].(string); nodeUUID != "" {
+			if node := f.findNode(nodeUUID); node != nil {
+				node.fields["allocation_uuid"] = nil
+				node.fields["instance_uuid"] = nil
+			}
+		}
+		delete(f.allocations, allocation["uuid"].(string))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// Pick a node for the allocation, the same way Ironic does: an available node that isn't in maintenance or
+// already allocated, with the right resource class and traits, from the candidate nodes if any are given.
+func (f *FakeIronic) allocate(allocation map[string]interface{}) {
+	resourceClass := allocation["resource_class"]
+	candidates, _ := allocation["candidate_nodes"].([]interface{})
+	traits, _ := allocation["traits"].([]interface{})
+
+	for _, id := range sortedKeys(f.nodes) {
+		node := f.nodes[id].fields
+		if node["resource_class"] != resourceClass || node["provision_state"] != "available" ||
+			node["maintenance"] == true || node["instance_uuid"] != nil || node["allocation_uuid"] != nil {
+			continue
+		}
+		if len(candidates) > 0 && !containsAny(candidates, node["uuid"], node["name"]) {
+			continue
+		}
+		nodeTraits, _ := node["traits"].([]interface{})
+		matches := true
+		for _, trait := range traits {
+			matches = matches && containsAny(nodeTraits, trait)
+		}
+		if !matches {
+			continue
+		}
+
+		allocation["state"] = "active"
+		allocation["node_uuid"] = id
+		node["allocation_uuid"] = allocation["uuid"]
+		node["instance_uuid"] = allocation["uuid"]
+		return
+	}
+
+	allocation["state"] = "error"
+	allocation["last_error"] = fmt.Sprintf("No available nodes match the resource class %s.", resourceClass)
+}
+
+func (f *FakeIronic) serveInspector(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && (parts[0] == "" || parts[0] == "v1") {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"versions": []map[string]interface{}{{"id": "1.18", "status": "CURRENT"}},
+		})
+		return
+	}
+	if len(parts) < 3 || parts[0] != "v1" || parts[1] != "introspection" || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+
+	introspection, ok := f.introspection[parts[2]]
+	if !ok || introspection.status["started_at"] == nil {
+		writeError(w, http.StatusNotFound, "Introspection for node %s not found", parts[2])
+		return
+	}
+
+	switch {
+	case len(parts) == 3:
+		writeJSON(w, http.StatusOK, introspection.status)
+	case len(parts) == 4 && parts[3] == "data":
+		if introspection.status["finished"] != true {
+			writeError(w, http.StatusNotFound, "Introspection data for node %s not found", parts[2])
+			return
+		}
+		writeJSON(w, http.StatusOK, introspection.data)
+	default:
+		writeError(w, http.StatusNotFound, "The resource could not be found.")
+	}
+}
+
+func (f *FakeIronic) addNode(fields map[string]interface{}) *fakeNode {
+	now := time.Now().UTC().Format(time.RFC3339)
+	node := &fakeNode{
+		fields: map[string]interface{}{
+			"uuid":                   uuid.New().String(),
+			"name":                   nil,
+			"provision_state":        "enroll",
+			"target_provision_state": nil,
+			"power_state":            nil,
+			"target_power_state":     nil,
+			"maintenance":            false,
+			"maintenance_reason":     nil,
+			"fault":                  nil,
+			"last_error":             nil,
+			"driver_info":            map[string]interface{}{},
+			"driver_internal_info":   map[string]interface{}{},
+			"properties":             map[string]interface{}{},
+			"instance_info":          map[string]interface{}{},
+			"instance_uuid":          nil,
+			"allocation_uuid":        nil,
+			"extra":                  map[string]interface{}{},
+			"traits":                 []interface{}{},
+			"raid_config":            map[string]interface{}{},
+			"target_raid_config":     map[string]interface{}{},
+			"conductor":              "fake-conductor",
+			"conductor_group":        "",
+			"created_at":             now,
+			"updated_at":             nil,
+			"provision_updated_at":   now,
+			"inspection_started_at":  nil,
+			"inspection_finished_at": nil,
+		},
+		failures:    make(map[string][]string),
+		bios:        make(map[string]string),
+		biosDetails: make(map[string]map[string]interface{}),
+	}
+	for _, field := range []string{"bios_interface", "boot_interface", "console_interface", "deploy_interface",
+		"inspect_interface", "management_interface", "network_interface", "power_interface", "raid_interface",
+		"rescue_interface", "storage_interface", "vendor_interface", "driver", "resource_class", "owner"} {
+		node.fields[field] = nil
+	}
+	for k, v := range fields {
+		node.fields[k] = v
+	}
+	f.nodes[node.fields["uuid"].(string)] = node
+
+	return node
+}
+
+func (f *FakeIronic) findNode(id string) *fakeNode {
+	if node, ok := f.nodes[id]; ok {
+		return node
+	}
+	for _, node := range f.nodes {
+		if name, _ := node.fields["name"].(string); name != "" && name == id {
+			return node
+		}
+	}
+	return nil
+}
+
+func (f *FakeIronic) findAllocation(id string) map[string]interface{} {
+	if allocation, ok := f.allocations[id]; ok {
+		return allocation
+	}
+	for _, allocation := range f.allocations {
+		if name, _ := allocation["name"].(string); name != "" && name == id {
+			return allocation
+		}
+	}
+	return nil
+}
+
+func (f *FakeIronic) introspectionFor(uuid string) *fakeIntrospection {
+	introspection, ok := f.introspection[uuid]
+	if !ok {
+		introspection = &fakeIntrospection{
+			status: map[string]interface{}{"uuid": uuid},
+			data:   map[string]interface{}{},
+		}
+		f.introspection[uuid] = introspection
+	}
+	return introspection
+}
+
+// render returns the node as Ironic's API shows it, with secrets in driver_info masked
+func (node *fakeNode) render() map[string]interface{} {
+	fields := copyMap(node.fields)
+	if driverInfo, ok := fields["driver_info"].(map[string]interface{}); ok {
+		for key := range driverInfo {
+			if strings.Contains(key, "password") || strings.Contains(key, "secret") {
+				driverInfo[key] = "******"
+			}
+		}
+	}
+	if instanceInfo, ok := fields["instance_info"].(map[string]interface{}); ok {
+		if _, ok := instanceInfo["rescue_password"]; ok {
+			instanceInfo["rescue_password"] = "******"
+		}
+	}
+	return fields
+}
+
+// Apply the RAID and BIOS clean steps right away, the rest of the steps don't change anything
+func (node *fakeNode) applyCleanSteps(steps []interface{}) {
+	for _, raw := range steps {
+		step, _ := raw.(map[string]interface{})
+		args, _ := step["args"].(map[string]interface{})
+		switch fmt.Sprintf("%s.%s", step["interface"], step["step"]) {
+		case "raid.delete_configuration":
+			node.fields["raid_config"] = map[string]interface{}{}
+		case "raid.create_configuration":
+			target, _ := node.fields["target_raid_config"].(map[string]interface{})
+			node.fields["raid_config"] = copyMap(target)
+		case "bios.apply_configuration":
+			settings, _ := args["settings"].([]interface{})
+			for _, raw := range settings {
+				setting, _ := raw.(map[string]interface{})
+				node.bios[fmt.Sprint(setting["name"])] = fmt.Sprint(setting["value"])
+			}
+		}
+	}
+}
+
+func (node *fakeNode) setProvisionState(state string) {
+	node.fields["provision_state"] = state
+	node.fields["provision_updated_at"] = time.Now().UTC().Format(time.RFC3339)
+	node.touch()
+}
+
+func (node *fakeNode) touch() {
+	node.fields["updated_at"] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// applyPatch applies a JSON patch, as sent by gophercloud's UpdateOpts, to a resource. The fields given can't be
+// changed.
+func applyPatch(doc map[string]interface{}, patch []map[string]interface{}, readOnly ...string) error {
+	for _, operation := range patch {
+		op, _ := operation["op"].(string)
+		path, _ := operation["path"].(string)
+
+		var keys []string
+		for _, key := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+			keys = append(keys, strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~"))
+		}
+		if len(keys) == 0 || keys[0] == "" {
+			return fmt.Errorf("invalid patch path '%s'", path)
+		}
+		for _, field := range readOnly {
+			if keys[0] == field {
+				return fmt.Errorf("'/%s' is an internal attribute and can not be updated", field)
+			}
+		}
+
+		// Walk to the parent of the value being changed
+		parent := doc
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				if op != "add" {
+					return fmt.Errorf("can't %s non-existent object '%s'", op, path)
+				}
+				child = make(map[string]interface{})
+				parent[key] = child
+			}
+			parent = child
+		}
+		key := keys[len(keys)-1]
+
+		switch op {
+		case "add":
+			parent[key] = operation["value"]
+		case "replace":
+			if _, ok := parent[key]; !ok {
+				return fmt.Errorf("can't replace non-existent object '%s'", path)
+			}
+			parent[key] = operation["value"]
+		case "remove":
+			if _, ok := parent[key]; !ok {
+				return fmt.Errorf("can't remove non-existent object '%s'", path)
+			}
+			if len(keys) == 1 {
+				// Removing a top-level field resets it, rather than deleting it
+				parent[key] = nil
+			} else {
+				delete(parent, key)
+			}
+		default:
+			return fmt.Errorf("unsupported patch operation '%s'", op)
+		}
+	}
+
+	return nil
+}
+
+// checkPatchFields refuses a patch changing a top-level field that isn't one of the resource's fields
+func checkPatchFields(patch []map[string]interface{}, fields []string) error {
+	for _, operation := range patch {
+		path, _ := operation["path"].(string)
+		field := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+		known := false
+		for _, f := range fields {
+			if field == f {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("adding a new attribute (/%s) to the root of the resource is not allowed", field)
+		}
+	}
+
+	return nil
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: %s", err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with an error in the format Ironic uses
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	message, _ := json.Marshal(map[string]interface{}{
+		"faultstring": fmt.Sprintf(format, args...),
+		"faultcode":   "Client",
+		"debuginfo":   nil,
+	})
+	writeJSON(w, code, map[string]interface{}{"error_message": string(message)})
+}
+
+// copyMap deep copies a JSON-like map
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	var result map[string]interface{}
+	data, _ := json.Marshal(m)
+	_ = json.Unmarshal(data, &result)
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsAny(list []interface{}, values ...interface{}) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if value != nil && item == value {
+				return true
+			}
+		}
+	}
+	return false
+}